@@ -0,0 +1,61 @@
+package epd
+
+import (
+	"bytes"
+	"image"
+	"math/bits"
+)
+
+// Update describes what the last Display, DisplayPartial or frame call did.
+type Update struct {
+	Skipped     bool            //the frame matched the one on screen and nothing was sent
+	Changed     image.Rectangle //pixels that differ from the previous frame, in panel coordinates; the whole panel when that was unknown
+	Partial     bool            //only the changed window was sent and refreshed
+	Refresh     Refresh
+	Forced      bool //the ghosting policy turned a fast or partial update into a full refresh
+	GhostingDue bool //a full refresh was due but DisplayPartial did not know the rest of the screen, so it sent the window alone
+}
+
+// LastUpdate reports the outcome of the last display call.
+func (e *Epd) LastUpdate() Update {
+	return e.lastUpdate
+}
+
+// changedRect is the bounding box of the pixels that differ between two
+// packed frames of width pixels, stride bytes per row and bpp bits per
+// pixel. A missing previous frame counts as all changed.
+func changedRect(prev, next []byte, width, stride, bpp int) image.Rectangle {
+	height := len(next) / stride
+	if len(prev) != len(next) {
+		return image.Rect(0, 0, width, height)
+	}
+	r := image.Rectangle{}
+	for y := 0; y < height; y++ {
+		row := y * stride
+		if bytes.Equal(prev[row:row+stride], next[row:row+stride]) {
+			continue
+		}
+		first, last := -1, -1
+		for i := 0; i < stride; i++ {
+			if prev[row+i] != next[row+i] {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+		lead := bits.LeadingZeros8(prev[row+first] ^ next[row+first])
+		trail := bits.TrailingZeros8(prev[row+last] ^ next[row+last])
+		line := image.Rect((first*8+lead)/bpp, y, (last*8+8-trail+bpp-1)/bpp, y+1)
+		r = r.Union(line)
+	}
+	return r.Intersect(image.Rect(0, 0, width, height))
+}
+
+func (p *Panel) changedMono(prev, next []byte) image.Rectangle {
+	return changedRect(prev, next, p.Width, p.Stride(), 1)
+}
+
+func (p *Panel) changedGray4(prev, next []byte) image.Rectangle {
+	return changedRect(prev, next, p.Width, (p.Width+3)/4, 2)
+}
@@ -0,0 +1,99 @@
+package epd
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/mipsmonsta/epd/emulator"
+	"github.com/mipsmonsta/epd/epd_config"
+)
+
+func TestChangedRect(t *testing.T) {
+	prev := make([]byte, 4*8)
+	next := append([]byte(nil), prev...)
+	if r := changedRect(prev, next, 32, 4, 1); !r.Empty() {
+		t.Fatalf("identical frames changed %v", r)
+	}
+	next[3*4+1] = 0x20 //x 10, y 3
+	next[5*4+2] = 0x08 //x 20, y 5
+	if r := changedRect(prev, next, 32, 4, 1); r != image.Rect(10, 3, 21, 6) {
+		t.Fatalf("mono changed %v, want (10,3)-(21,6)", r)
+	}
+	if r := changedRect(prev, next, 16, 4, 2); r != image.Rect(5, 3, 11, 6) {
+		t.Fatalf("gray changed %v, want (5,3)-(11,6)", r)
+	}
+	if r := changedRect(nil, next, 32, 4, 1); r != image.Rect(0, 0, 32, 8) {
+		t.Fatalf("unknown previous frame changed %v, want everything", r)
+	}
+}
+
+func TestIdenticalFrameIsSkipped(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+	img := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black)
+
+	e.Display(&img, MODE_MONO_DITHER_OFF)
+	refreshes := em.Refreshes()
+	e.Display(&img, MODE_MONO_DITHER_OFF)
+	if !e.LastUpdate().Skipped || em.Refreshes() != refreshes {
+		t.Fatalf("identical frame sent again, update %+v", e.LastUpdate())
+	}
+
+	e.Display(&img, MODE_MONO_DITHER_OFF, WithForce())
+	if e.LastUpdate().Skipped || em.Refreshes() != refreshes+1 {
+		t.Fatal("forced frame not sent")
+	}
+}
+
+func TestPartialRefreshOfChangedWindow(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+	e.Clear()
+
+	src := image.NewRGBA(image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(20, 40, 30, 50), image.Black, image.Point{}, draw.Src)
+	img := image.Image(src)
+
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF, WithRefresh(REFRESH_PARTIAL)); err != nil {
+		t.Fatal(err)
+	}
+	u := e.LastUpdate()
+	if !u.Partial || u.Changed != image.Rect(20, 40, 30, 50) {
+		t.Fatalf("update %+v, want a partial refresh of (20,40)-(30,50)", u)
+	}
+	if em.PartialRefreshes() != 1 {
+		t.Fatalf("%d partial refreshes, want 1", em.PartialRefreshes())
+	}
+	if em.Image(emulator.RENDER_MONO).At(25, 45) != color.Black {
+		t.Fatal("changed window not shown")
+	}
+
+	other, _ := NewEpd(&epd_config.FakeConfig{}, MODEL_4IN2)
+	other.Setup()
+	img = newUniformImage(400, 300, color.Black)
+	if err := other.Display(&img, MODE_MONO_DITHER_OFF, WithRefresh(REFRESH_PARTIAL)); err != nil {
+		t.Fatal(err)
+	}
+	if u := other.LastUpdate(); u.Partial || u.Refresh != REFRESH_FULL {
+		t.Fatalf("update %+v, want a full refresh on a panel without partial", u)
+	}
+}
+
+func TestIdenticalGray4FrameIsSkipped(t *testing.T) {
+	e, _ := newEmulatedEpd()
+	e.Setup_4Gray()
+	f := e.panel().NewGray4Frame()
+	f.SetColorIndex(3, 3, 1)
+
+	e.DisplayGray4Frame(f)
+	if e.LastUpdate().Changed != image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT) {
+		t.Fatalf("first frame changed %v, want the whole panel", e.LastUpdate().Changed)
+	}
+	e.DisplayGray4Frame(f)
+	if !e.LastUpdate().Skipped {
+		t.Fatal("identical 4 gray frame sent again")
+	}
+}
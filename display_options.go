@@ -0,0 +1,103 @@
+package epd
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/mipsmonsta/epd/imageutil"
+)
+
+type Refresh int
+
+const (
+	REFRESH_FULL    Refresh = 0 //full waveform, flashes the screen but clears ghosting
+	REFRESH_FAST    Refresh = 1 //Panel.FastLut, one short phase that leaves some ghosting
+	REFRESH_PARTIAL Refresh = 2 //only the changed window, on panels with PartialRefresh; full otherwise
+)
+
+type displayOptions struct {
+	refresh     Refresh
+	force       bool
+	fit         *imageutil.FitOptions //nil is the zero FitOptions: contained, centered on white
+	ditherer    Ditherer              //nil keeps the built-in Floyd-Steinberg, or no dithering in 4 gray
+	tone        *imageutil.ToneOptions
+	thresholder Thresholder //nil keeps Otsu
+	background  color.Color //under transparent pixels; nil is white
+}
+
+// DisplayOption changes how a single Display call updates the panel.
+type DisplayOption func(*displayOptions)
+
+// WithRefresh picks the waveform for this call; the default is REFRESH_FULL.
+func WithRefresh(r Refresh) DisplayOption {
+	return func(o *displayOptions) {
+		o.refresh = r
+	}
+}
+
+// WithForce sends the frame even when it matches the one on screen.
+func WithForce() DisplayOption {
+	return func(o *displayOptions) {
+		o.force = true
+	}
+}
+
+// WithFit sizes the image with imageutil.FitImageWith, e.g. ScaleCover so
+// a photo fills the screen or ScaleContain to center a logo on white.
+func WithFit(fit imageutil.FitOptions) DisplayOption {
+	return func(o *displayOptions) {
+		o.fit = &fit
+	}
+}
+
+// WithDitherer picks the kernel MODE_MONO_DITHER_ON dithers with, e.g.
+// Atkinson for UI and line art or Bayer4 for flat, stable fills. Given to
+// Display_4Gray it dithers to the four gray levels instead of banding.
+func WithDitherer(d Ditherer) DisplayOption {
+	return func(o *displayOptions) {
+		o.ditherer = d
+	}
+}
+
+// WithThresholder picks how the mono modes and DisplayPartial split black
+// from white, e.g. Sauvola for unevenly lit scans. With MODE_MONO_DITHER_ON
+// and no WithDitherer, Floyd-Steinberg dithers around its cuts.
+func WithThresholder(t Thresholder) DisplayOption {
+	return func(o *displayOptions) {
+		o.thresholder = t
+	}
+}
+
+// WithTone shapes the gray levels before they are thresholded or dithered,
+// e.g. AutoLevels to rescue a dark photo. It applies to the mono and 4 gray
+// modes, not to MODE_TRICOLOR.
+func WithTone(tone imageutil.ToneOptions) DisplayOption {
+	return func(o *displayOptions) {
+		o.tone = &tone
+	}
+}
+
+// WithBackground sets the color transparent parts of the image are
+// flattened onto before fitting, white when not given. It applies to every
+// mode, so a transparent logo looks the same in mono, 4 gray and tri-color.
+func WithBackground(c color.Color) DisplayOption {
+	return func(o *displayOptions) {
+		o.background = c
+	}
+}
+
+// adjust applies the tone options, if any, to a fitted image.
+func (o displayOptions) adjust(img image.Image) image.Image {
+	if o.tone == nil {
+		return img
+	}
+	return imageutil.AdjustTone(&img, *o.tone)
+}
+
+func newDisplayOptions(opts []DisplayOption) displayOptions {
+	var o displayOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
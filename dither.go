@@ -0,0 +1,305 @@
+package epd
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/mipsmonsta/epd/imageutil"
+)
+
+// Quantizer maps 8 bit gray values to the levels a panel can show: values
+// up to Cuts[i] become level i, values above the last cut the last level.
+type Quantizer struct {
+	Levels []uint8     //gray value of each level, darkest first
+	Cuts   []int       //len(Levels)-1 ascending upper bounds
+	Local  *image.Gray //per pixel first cut, from a local Thresholder; nil to use Cuts[0] everywhere
+}
+
+// MonoQuantizer is black up to threshold and white above it.
+func MonoQuantizer(threshold int) Quantizer {
+	return Quantizer{Levels: []uint8{0, 255}, Cuts: []int{threshold}}
+}
+
+var (
+	// Gray4Quantizer has the four levels of Display_4Gray with cuts midway
+	// between them, so dithering to it keeps the average tone.
+	Gray4Quantizer = Quantizer{Levels: []uint8{0x00, 0x55, 0xAA, 0xFF}, Cuts: []int{42, 127, 212}}
+	// Gray4BandQuantizer is what ConvertImageto4GrayEPDTensor cuts into
+	// bands with; its cut-offs can be calibrated to shape the 8 bit to 2 bit
+	// curve.
+	Gray4BandQuantizer = Quantizer{Levels: []uint8{0x00, 0x55, 0xAA, 0xFF}, Cuts: []int{99, 139, 179}}
+)
+
+// Level returns the index of the level v is shown as, ignoring Local.
+func (q Quantizer) Level(v int) int {
+	for i, c := range q.Cuts {
+		if v <= c {
+			return i
+		}
+	}
+	return len(q.Cuts)
+}
+
+// LevelAt is Level for the pixel at x, y of the image being quantized.
+func (q Quantizer) LevelAt(v, x, y int) int {
+	for i := range q.Cuts {
+		if v <= q.cut(i, x, y) {
+			return i
+		}
+	}
+	return len(q.Cuts)
+}
+
+func (q Quantizer) cut(i, x, y int) int {
+	if i == 0 && q.Local != nil {
+		return int(q.Local.Pix[x+y*q.Local.Stride])
+	}
+	return q.Cuts[i]
+}
+
+// span returns the lower of the two levels v is mixed from at pixel x, y
+// and how far v is towards the upper one, 0 to 1, stretched so the cut
+// between them sits at one half. The pair is picked around the level
+// LevelAt gives, so that level is the one most pixels get.
+func (q Quantizer) span(v, x, y int) (lower int, f float64) {
+	lower = q.LevelAt(v, x, y)
+	if lower == len(q.Cuts) || (lower > 0 && v < int(q.Levels[lower])) {
+		lower--
+	}
+	lo, hi := float64(q.Levels[lower]), float64(q.Levels[lower+1])
+	c, fv := float64(q.cut(lower, x, y))+0.5, float64(v)
+	switch {
+	case fv <= c && c > lo:
+		f = 0.5 * (fv - lo) / (c - lo)
+	case fv > c && hi > c:
+		f = 0.5 + 0.5*(fv-c)/(hi-c)
+	case fv > c:
+		f = 1
+	}
+	return lower, math.Max(0, math.Min(1, f))
+}
+
+func (q Quantizer) palette() color.Palette {
+	pal := make(color.Palette, len(q.Levels))
+	for i, l := range q.Levels {
+		pal[i] = color.Gray{Y: l}
+	}
+	return pal
+}
+
+// Ditherer reduces a grayscale image to the levels of q, spreading the
+// difference over neighbouring pixels so the average tone is kept. The
+// result holds level indices with a palette of q.Levels.
+type Ditherer interface {
+	Dither(gray *image.Gray, q Quantizer) *image.Paletted
+}
+
+type diffusionTap struct {
+	dx, dy, weight int
+}
+
+// ErrorDiffusion pushes each pixel's quantization error onto the pixels
+// ahead of and below it, weighted by a kernel. Rows run left to right
+// unless the kernel is serpentine, see Serpentine.
+type ErrorDiffusion struct {
+	Name       string
+	taps       []diffusionTap
+	divisor    int
+	serpentine bool
+}
+
+var (
+	FloydSteinberg = &ErrorDiffusion{Name: "Floyd-Steinberg", divisor: 16, taps: []diffusionTap{
+		{1, 0, 7},
+		{-1, 1, 3}, {0, 1, 5}, {1, 1, 1},
+	}}
+	Atkinson = &ErrorDiffusion{Name: "Atkinson", divisor: 8, taps: []diffusionTap{
+		{1, 0, 1}, {2, 0, 1},
+		{-1, 1, 1}, {0, 1, 1}, {1, 1, 1},
+		{0, 2, 1},
+	}}
+	JarvisJudiceNinke = &ErrorDiffusion{Name: "Jarvis-Judice-Ninke", divisor: 48, taps: []diffusionTap{
+		{1, 0, 7}, {2, 0, 5},
+		{-2, 1, 3}, {-1, 1, 5}, {0, 1, 7}, {1, 1, 5}, {2, 1, 3},
+		{-2, 2, 1}, {-1, 2, 3}, {0, 2, 5}, {1, 2, 3}, {2, 2, 1},
+	}}
+	Stucki = &ErrorDiffusion{Name: "Stucki", divisor: 42, taps: []diffusionTap{
+		{1, 0, 8}, {2, 0, 4},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 8}, {1, 1, 4}, {2, 1, 2},
+		{-2, 2, 1}, {-1, 2, 2}, {0, 2, 4}, {1, 2, 2}, {2, 2, 1},
+	}}
+	Burkes = &ErrorDiffusion{Name: "Burkes", divisor: 32, taps: []diffusionTap{
+		{1, 0, 8}, {2, 0, 4},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 8}, {1, 1, 4}, {2, 1, 2},
+	}}
+	Sierra = &ErrorDiffusion{Name: "Sierra", divisor: 32, taps: []diffusionTap{
+		{1, 0, 5}, {2, 0, 3},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 5}, {1, 1, 4}, {2, 1, 2},
+		{-1, 2, 2}, {0, 2, 3}, {1, 2, 2},
+	}}
+	TwoRowSierra = &ErrorDiffusion{Name: "Two-row Sierra", divisor: 16, taps: []diffusionTap{
+		{1, 0, 4}, {2, 0, 3},
+		{-2, 1, 1}, {-1, 1, 2}, {0, 1, 3}, {1, 1, 2}, {2, 1, 1},
+	}}
+	SierraLite = &ErrorDiffusion{Name: "Sierra Lite", divisor: 4, taps: []diffusionTap{
+		{1, 0, 2},
+		{-1, 1, 1}, {0, 1, 1},
+	}}
+)
+
+func (d *ErrorDiffusion) String() string { return d.Name }
+
+// Serpentine returns a copy of d that runs odd rows right to left with the
+// kernel mirrored, which breaks up the diagonal worms a one way scan leaves
+// in flat areas.
+func (d *ErrorDiffusion) Serpentine() *ErrorDiffusion {
+	s := *d
+	s.Name = d.Name + " serpentine"
+	s.serpentine = true
+	return &s
+}
+
+func (d *ErrorDiffusion) Dither(gray *image.Gray, q Quantizer) *image.Paletted {
+	b := gray.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := image.NewPaletted(b, q.palette())
+
+	//signed, so errors from pixels rounded up darken their neighbours
+	buf := make([]int, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			buf[x+y*w] = int(gray.Pix[gray.PixOffset(b.Min.X+x, b.Min.Y+y)])
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		x, step := 0, 1
+		if d.serpentine && y%2 == 1 {
+			x, step = w-1, -1
+		}
+		for ; x >= 0 && x < w; x += step {
+			v := clampGray(buf[x+y*w])
+			level := q.LevelAt(v, x, y)
+			out.Pix[x+y*out.Stride] = uint8(level)
+			quantError := v - int(q.Levels[level])
+			if quantError == 0 {
+				continue
+			}
+			for _, t := range d.taps {
+				nx, ny := x+t.dx*step, y+t.dy
+				if nx < 0 || nx >= w || ny >= h {
+					continue
+				}
+				buf[nx+ny*w] += quantError * t.weight / d.divisor
+			}
+		}
+	}
+	return out
+}
+
+// OrderedDither picks between the two levels around each pixel by comparing
+// its position between them with a Bayer threshold matrix. It keeps no
+// state between pixels, so flat areas get a regular pattern that does not
+// crawl between frames.
+type OrderedDither struct {
+	Name   string
+	size   int
+	matrix []int //size x size, values 0 to size*size-1
+}
+
+var (
+	Bayer2 = newBayer(2)
+	Bayer4 = newBayer(4)
+	Bayer8 = newBayer(8)
+)
+
+// newBayer builds the size x size index matrix, size a power of 2, by
+// repeatedly tiling [[4m, 4m+2], [4m+3, 4m+1]].
+func newBayer(size int) *OrderedDither {
+	m, n := []int{0}, 1
+	for n < size {
+		next := make([]int, 4*n*n)
+		for y := 0; y < n; y++ {
+			for x := 0; x < n; x++ {
+				v := 4 * m[x+y*n]
+				next[x+y*2*n] = v
+				next[x+n+y*2*n] = v + 2
+				next[x+(y+n)*2*n] = v + 3
+				next[x+n+(y+n)*2*n] = v + 1
+			}
+		}
+		m, n = next, 2*n
+	}
+	return &OrderedDither{Name: fmt.Sprintf("Bayer %dx%d", size, size), size: size, matrix: m}
+}
+
+func (d *OrderedDither) String() string { return d.Name }
+
+func (d *OrderedDither) Dither(gray *image.Gray, q Quantizer) *image.Paletted {
+	b := gray.Bounds()
+	out := image.NewPaletted(b, q.palette())
+	cells := float64(d.size * d.size)
+	imageutil.ParallelRows(b.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < b.Dx(); x++ {
+				threshold := (float64(d.matrix[x%d.size+(y%d.size)*d.size]) + 0.5) / cells
+				level, f := q.span(int(gray.Pix[gray.PixOffset(b.Min.X+x, b.Min.Y+y)]), x, y)
+				if f > threshold {
+					level++
+				}
+				out.Pix[x+y*out.Stride] = uint8(level)
+			}
+		}
+	})
+	return out
+}
+
+func clampGray(v int) int {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+// ConvertImagetoMonochromeEPDTensorWithDitherer is
+// ConvertImagetoMonochromeEPDTensorWithDither with the ditherer of choice,
+// around the same Otsu threshold.
+func ConvertImagetoMonochromeEPDTensorWithDitherer(img *image.Image, d Ditherer) (monochrome [][]uint8, err error) {
+	return ConvertImagetoMonochromeEPDTensorWith(img, Otsu, d)
+}
+
+// ConvertImageto4GrayEPDTensorWithDitherer dithers img to the four levels
+// of Gray4Quantizer instead of cutting it into bands like
+// ConvertImageto4GrayEPDTensor, which removes the banding in photos.
+func ConvertImageto4GrayEPDTensorWithDitherer(img *image.Image, d Ditherer) (gray [][]uint8, err error) {
+	return levelTensor(d.Dither(imageutil.ToGray(*img), Gray4Quantizer), gray4TensorLevels), nil
+}
+
+// tensor values of each level for GetEPDBuffer and GetEPDBuffer_4Gray
+var (
+	monoTensorLevels  = []uint8{0, 255}
+	gray4TensorLevels = []uint8{0x00, 0x40, 0x80, 0xC0} //2 bit level in the top bits
+)
+
+// levelTensor turns level indices into an x, y tensor holding
+// values[level], the form GetEPDBuffer and GetEPDBuffer_4Gray take. The
+// columns share one allocation.
+func levelTensor(levels *image.Paletted, values []uint8) [][]uint8 {
+	size := levels.Bounds().Size()
+	backing := make([]uint8, size.X*size.Y)
+	tensor := make([][]uint8, size.X)
+	for x := range tensor {
+		tensor[x] = backing[x*size.Y : (x+1)*size.Y : (x+1)*size.Y]
+	}
+	for y := 0; y < size.Y; y++ {
+		for x, l := range levels.Pix[y*levels.Stride : y*levels.Stride+size.X] {
+			tensor[x][y] = values[l]
+		}
+	}
+	return tensor
+}
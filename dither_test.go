@@ -0,0 +1,235 @@
+package epd
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/mipsmonsta/epd/emulator"
+)
+
+var allDitherers = []Ditherer{
+	FloydSteinberg, FloydSteinberg.Serpentine(), Atkinson, JarvisJudiceNinke, Stucki, Burkes, Sierra, TwoRowSierra, SierraLite,
+	Bayer2, Bayer4, Bayer8,
+}
+
+func flatGray(w, h int, v uint8) *image.Gray {
+	g := image.NewGray(image.Rect(0, 0, w, h))
+	for i := range g.Pix {
+		g.Pix[i] = v
+	}
+	return g
+}
+
+func whiteShare(p *image.Paletted) float64 {
+	white := 0
+	for _, l := range p.Pix {
+		white += int(l)
+	}
+	return float64(white) / float64(len(p.Pix))
+}
+
+func TestDitherersKeepAverageTone(t *testing.T) {
+	for _, d := range allDitherers {
+		for _, v := range []uint8{64, 128, 192} {
+			out := d.Dither(flatGray(64, 64, v), MonoQuantizer(127))
+			want, tolerance := float64(v)/255, 0.05
+			if d == Atkinson {
+				tolerance = 0.12 //diffuses only 6/8 of the error, which adds contrast
+			}
+			if got := whiteShare(out); got < want-tolerance || got > want+tolerance {
+				t.Errorf("%v on gray %d: %.2f white, want about %.2f", d, v, got, want)
+			}
+		}
+	}
+}
+
+func TestDitherersKeepBlackAndWhite(t *testing.T) {
+	for _, d := range allDitherers {
+		if got := whiteShare(d.Dither(flatGray(16, 16, 0), MonoQuantizer(127))); got != 0 {
+			t.Errorf("%v turned black %.2f white", d, got)
+		}
+		if got := whiteShare(d.Dither(flatGray(16, 16, 255), MonoQuantizer(127))); got != 1 {
+			t.Errorf("%v turned white %.2f white", d, got)
+		}
+	}
+}
+
+func TestBayerMatrix(t *testing.T) {
+	want := []int{0, 8, 2, 10, 12, 4, 14, 6, 3, 11, 1, 9, 15, 7, 13, 5}
+	for i, v := range Bayer4.matrix {
+		if v != want[i] {
+			t.Fatalf("Bayer4 = %v, want %v", Bayer4.matrix, want)
+		}
+	}
+	//mid gray on 2x2 is a checkerboard
+	out := Bayer2.Dither(flatGray(4, 4, 128), MonoQuantizer(127))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if out.Pix[x+y*4] == out.Pix[(x+1)%4+y*4] {
+				t.Fatalf("row %d is not alternating: %v", y, out.Pix[y*4:y*4+4])
+			}
+		}
+	}
+}
+
+// worked by hand: errors are signed, 7/16 goes to the right and division
+// truncates towards zero
+func TestFloydSteinbergReference(t *testing.T) {
+	for _, c := range []struct {
+		d    *ErrorDiffusion
+		want []uint8
+	}{
+		{FloydSteinberg, []uint8{0, 1, 0, 0, 1, 0}},
+		{FloydSteinberg.Serpentine(), []uint8{0, 1, 0, 1, 0, 0}},
+	} {
+		out := c.d.Dither(flatGray(3, 2, 100), MonoQuantizer(127))
+		for i, l := range out.Pix {
+			if l != c.want[i] {
+				t.Errorf("%v = %v, want %v", c.d, out.Pix, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSerpentineKeepsKernel(t *testing.T) {
+	s := Stucki.Serpentine()
+	if !s.serpentine || Stucki.serpentine || len(s.taps) != len(Stucki.taps) || s.divisor != Stucki.divisor {
+		t.Fatalf("Serpentine() = %+v from %+v", s, Stucki)
+	}
+}
+
+func TestDitherKeepsHighlightDetail(t *testing.T) {
+	g := flatGray(64, 64, 40)
+	for y := 0; y < 64; y++ {
+		for x := 32; x < 64; x++ {
+			g.Pix[x+y*g.Stride] = 200
+		}
+	}
+	var img image.Image = g
+	mono, _ := ConvertImagetoMonochromeEPDTensorWithDither(&img)
+	black := 0
+	for x := 32; x < 64; x++ {
+		for _, v := range mono[x] {
+			if v == 0 {
+				black++
+			}
+		}
+	}
+	//200 is about 78% white, so a fifth of the light half should be black
+	if share := float64(black) / (32 * 64); share < 0.17 || share > 0.27 {
+		t.Errorf("light half %.2f black, want about 0.22", share)
+	}
+}
+
+func TestEmulatedDisplayWithDitherer(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+
+	//left half mid gray, right half white so Otsu has two classes
+	src := image.NewRGBA(image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, EPD_WIDTH/2, EPD_HEIGHT), image.NewUniform(color.Gray{Y: 0x60}), image.Point{}, draw.Src)
+	img := image.Image(src)
+	if err := e.Display(&img, MODE_MONO_DITHER_ON, WithDitherer(Bayer2)); err != nil {
+		t.Fatal(err)
+	}
+	out := em.Image(emulator.RENDER_MONO)
+	black, white := 0, 0
+	for x := 0; x < 8; x++ {
+		if out.At(x, EPD_HEIGHT/2) == color.Black {
+			black++
+		} else {
+			white++
+		}
+	}
+	if black == 0 || white == 0 {
+		t.Fatalf("gray half should be a pattern, got %d black and %d white", black, white)
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
+
+func TestDitherersToGray4(t *testing.T) {
+	for _, d := range allDitherers {
+		out := d.Dither(flatGray(64, 64, 120), Gray4Quantizer)
+		seen := map[uint8]bool{}
+		sum := 0
+		for _, l := range out.Pix {
+			seen[l] = true
+			sum += int(Gray4Quantizer.Levels[l])
+		}
+		if seen[0] || seen[3] {
+			t.Errorf("%v used levels %v for gray 120, want only 1 and 2", d, seen)
+		}
+		tolerance := 5
+		if d == Bayer2 {
+			tolerance = 11 //four cells mix two levels in steps of a quarter, 21 apart here
+		}
+		if mean := sum / len(out.Pix); mean < 120-tolerance || mean > 120+tolerance {
+			t.Errorf("%v mean %d, want about 120", d, mean)
+		}
+	}
+}
+
+func TestBayerGray4KeepsTone(t *testing.T) {
+	for _, v := range []uint8{30, 60, 100, 120, 150, 200, 240} {
+		out := Bayer4.Dither(flatGray(64, 64, v), Gray4Quantizer)
+		sum := 0
+		for _, l := range out.Pix {
+			sum += int(Gray4Quantizer.Levels[l])
+		}
+		if mean := sum / len(out.Pix); mean < int(v)-4 || mean > int(v)+4 {
+			t.Errorf("gray %d: mean %d", v, mean)
+		}
+	}
+}
+
+func TestOrderedDitherAgreesWithLevelAt(t *testing.T) {
+	//the band cuts are not midway; the level most pixels get must still be
+	//the one thresholding gives
+	q := Gray4BandQuantizer
+	for v := 0; v < 256; v++ {
+		out := Bayer4.Dither(flatGray(16, 16, uint8(v)), q)
+		counts := make([]int, len(q.Levels))
+		for _, l := range out.Pix {
+			counts[l]++
+		}
+		if want := q.Level(v); counts[want]*2 < len(out.Pix) {
+			t.Fatalf("gray %d: levels %v, want mostly %d", v, counts, want)
+		}
+	}
+}
+
+func TestEmulatedGray4WithDitherer(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup_4Gray()
+
+	//a smooth ramp that bands without dithering
+	src := image.NewGray(image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT))
+	for y := 0; y < EPD_HEIGHT; y++ {
+		for x := 0; x < EPD_WIDTH; x++ {
+			src.SetGray(x, y, color.Gray{Y: uint8(y * 255 / EPD_HEIGHT)})
+		}
+	}
+	img := image.Image(src)
+
+	if err := e.Display_4Gray(&img, WithDitherer(JarvisJudiceNinke)); err != nil {
+		t.Fatal(err)
+	}
+	out := em.Image(emulator.RENDER_GRAY4)
+	//between the 85 and 170 levels the row mixes both
+	row := map[color.Color]bool{}
+	for x := 0; x < EPD_WIDTH; x++ {
+		row[out.At(x, EPD_HEIGHT*120/255)] = true
+	}
+	if len(row) < 2 {
+		t.Fatalf("dithered row has a single level")
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
@@ -0,0 +1,420 @@
+package emulator
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mipsmonsta/epd/epd_config"
+	"periph.io/x/conn/v3/gpio"
+)
+
+// Emulator is a software model of the IL91874 controller on the 2.7" HAT.
+// It implements epd_config.Transport, decodes the command stream the Epd
+// driver sends and keeps the state a real panel would show.
+type Emulator struct {
+	Width     int
+	Height    int
+	MaxTx     int           //largest accepted WriteBytes, like the spidev buffer
+	TxLatency time.Duration //simulated fixed cost of one SPI transaction
+
+	rst gpio.Level
+	dc  gpio.Level
+	cs  gpio.Level
+
+	cmd    byte
+	hasCmd bool
+	params []byte
+
+	ram   map[byte][]byte //RAM planes written with 0x10 (old) and 0x13 (new)
+	shown map[byte][]byte //planes latched onto the glass by the last refresh
+	luts  map[byte][]byte
+
+	border           byte
+	temperature      int8 //0xE5, used when 0xE0 sets TSFIX
+	tsfix            bool
+	poweredOn        bool
+	asleep           bool
+	closed           bool
+	refreshes        int
+	partialRefreshes int
+	transactions     int
+	errors           []string
+}
+
+type RenderMode int
+
+const (
+	RENDER_MONO     RenderMode = 0 //0x13 plane only, 1 is white
+	RENDER_GRAY4    RenderMode = 1 //0x10 and 0x13 bits form a 2 bit gray level
+	RENDER_TRICOLOR RenderMode = 2 //0x10 set is black, 0x13 set is red (B variant)
+)
+
+var (
+	Gray4Palette = color.Palette{
+		color.Gray{Y: 0x00},
+		color.Gray{Y: 0x55},
+		color.Gray{Y: 0xAA},
+		color.Gray{Y: 0xFF},
+	}
+	TriColorPalette = color.Palette{
+		color.White,
+		color.Black,
+		color.RGBA{R: 0xFF, A: 0xFF},
+	}
+)
+
+// New returns an emulated panel of width x height pixels in portrait
+// orientation, i.e. New(176, 264) for the 2.7" HAT.
+func New(width, height int) *Emulator {
+	em := &Emulator{
+		Width:  width,
+		Height: height,
+		MaxTx:  epd_config.DEFAULT_MAX_TX_SIZE,
+		rst:    gpio.High,
+		cs:     gpio.High,
+	}
+	em.powerOnReset()
+	return em
+}
+
+var _ epd_config.Transport = (*Emulator)(nil)
+var _ epd_config.TxLimiter = (*Emulator)(nil)
+
+func (em *Emulator) planeSize() int {
+	return (em.Width + 7) / 8 * em.Height
+}
+
+func (em *Emulator) powerOnReset() {
+	em.ram = map[byte][]byte{
+		0x10: make([]byte, em.planeSize()),
+		0x13: make([]byte, em.planeSize()),
+	}
+	if em.shown == nil { //the glass keeps its image across resets
+		em.shown = map[byte][]byte{
+			0x10: whitePlane(em.planeSize()),
+			0x13: whitePlane(em.planeSize()),
+		}
+	}
+	em.luts = map[byte][]byte{}
+	em.tsfix = false
+	em.hasCmd = false
+	em.params = nil
+	em.poweredOn = false
+	em.asleep = false
+}
+
+func whitePlane(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = 0xFF
+	}
+	return b
+}
+
+func (em *Emulator) Setup() error {
+	em.closed = false
+	return nil
+}
+
+func (em *Emulator) Digital_writeRST(l gpio.Level) error {
+	if em.rst == gpio.High && l == gpio.Low { //reset is triggered on the falling edge
+		em.powerOnReset()
+	}
+	em.rst = l
+	return nil
+}
+
+func (em *Emulator) Digital_writeDC(l gpio.Level) error {
+	em.dc = l
+	return nil
+}
+
+func (em *Emulator) Digital_writeCS(l gpio.Level) error {
+	em.cs = l
+	return nil
+}
+
+func (em *Emulator) Digital_readBS() gpio.Level {
+	return gpio.High //never busy, refreshes are instantaneous
+}
+
+func (em *Emulator) Destroy() error {
+	em.closed = true
+	return nil
+}
+
+func (em *Emulator) MaxTxSize() int {
+	return em.MaxTx
+}
+
+func (em *Emulator) WriteBytes(data []byte) error {
+	em.transactions++
+	if em.TxLatency > 0 {
+		time.Sleep(em.TxLatency)
+	}
+	switch {
+	case len(data) > em.MaxTx:
+		em.fail(fmt.Sprintf("transfer of %d bytes exceeds %d", len(data), em.MaxTx))
+		return fmt.Errorf("%w: %d bytes exceeds %d", epd_config.ErrTransfer, len(data), em.MaxTx)
+	case em.closed:
+		em.fail("write after transport was closed")
+		return fmt.Errorf("%w: emulated port is closed", epd_config.ErrTransfer)
+	case em.cs == gpio.High:
+		em.fail("write with CS high")
+		return nil
+	case em.asleep:
+		em.fail("write while in deep sleep")
+		return nil
+	}
+	for _, b := range data {
+		if em.dc == gpio.Low {
+			em.command(b)
+		} else {
+			em.data(b)
+		}
+	}
+	return nil
+}
+
+func (em *Emulator) fail(msg string) {
+	em.errors = append(em.errors, msg)
+}
+
+func (em *Emulator) command(c byte) {
+	em.cmd = c
+	em.hasCmd = true
+	em.params = em.params[:0]
+
+	switch c {
+	case 0x02: //power off
+		em.poweredOn = false
+	case 0x04: //power on
+		em.poweredOn = true
+	case 0x12: //display refresh
+		em.refresh()
+	}
+}
+
+func (em *Emulator) data(b byte) {
+	if !em.hasCmd {
+		em.fail("data without a command")
+		return
+	}
+	em.params = append(em.params, b)
+	n := len(em.params)
+
+	switch {
+	case em.cmd == 0x14 || em.cmd == 0x15: //partial data: window, then pixels
+		if n > 8 {
+			em.partialData(b, n-9)
+		}
+	case em.cmd == 0x16: //partial refresh; Setup sends it with one byte to disable
+		if n == 8 {
+			em.partialRefresh()
+		}
+	case em.cmd == 0x10 || em.cmd == 0x13:
+		plane := em.ram[em.cmd]
+		if n > len(plane) {
+			em.fail(fmt.Sprintf("plane %#x overflow", em.cmd))
+			return
+		}
+		plane[n-1] = b
+	case em.cmd >= 0x20 && em.cmd <= 0x25:
+		em.luts[em.cmd] = append([]byte(nil), em.params...)
+	case em.cmd == 0x50:
+		if n == 1 {
+			em.border = b
+		}
+	case em.cmd == 0xE0: //cascade setting
+		if n == 1 {
+			em.tsfix = b&0x02 != 0
+		}
+	case em.cmd == 0xE5: //force temperature
+		if n == 1 {
+			em.temperature = int8(b)
+		}
+	case em.cmd == 0x07:
+		if n == 1 && b == 0xA5 { //deep sleep check code
+			em.asleep = true
+			em.poweredOn = false
+		}
+	}
+}
+
+// window decodes the x, y, w, l parameters of 0x14, 0x15 and 0x16. The
+// controller ignores the low 3 bits of x and w.
+func window(p []byte) image.Rectangle {
+	x := int(p[0])<<8 | int(p[1]&0xF8)
+	y := int(p[2])<<8 | int(p[3])
+	w := int(p[4])<<8 | int(p[5]&0xF8)
+	l := int(p[6])<<8 | int(p[7])
+	return image.Rect(x, y, x+w, y+l)
+}
+
+func (em *Emulator) partialData(b byte, k int) {
+	r := window(em.params)
+	rowBytes := r.Dx() / 8
+	if rowBytes == 0 || k >= rowBytes*r.Dy() {
+		em.fail(fmt.Sprintf("partial data %#x overflows window %v", em.cmd, r))
+		return
+	}
+	x := r.Min.X/8 + k%rowBytes
+	y := r.Min.Y + k/rowBytes
+	index := y*((em.Width+7)/8) + x
+	if x >= (em.Width+7)/8 || index >= em.planeSize() {
+		em.fail(fmt.Sprintf("partial window %v outside panel", r))
+		return
+	}
+	plane := em.ram[0x10]
+	if em.cmd == 0x15 {
+		plane = em.ram[0x13]
+	}
+	plane[index] = b
+}
+
+func (em *Emulator) partialRefresh() {
+	if !em.poweredOn {
+		em.fail("partial refresh while powered off")
+		return
+	}
+	r := window(em.params).Intersect(image.Rect(0, 0, em.Width, em.Height))
+	stride := (em.Width + 7) / 8
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		from, to := y*stride+r.Min.X/8, y*stride+(r.Max.X+7)/8
+		for k, plane := range em.ram {
+			copy(em.shown[k][from:to], plane[from:to])
+		}
+	}
+	em.partialRefreshes++
+}
+
+func (em *Emulator) refresh() {
+	if !em.poweredOn {
+		em.fail("refresh while powered off")
+		return
+	}
+	for k, plane := range em.ram {
+		copy(em.shown[k], plane)
+	}
+	em.refreshes++
+}
+
+// Refreshes is the number of display refreshes (0x12) that reached the glass.
+func (em *Emulator) Refreshes() int {
+	return em.refreshes
+}
+
+// PartialRefreshes is the number of window refreshes (0x16) that reached
+// the glass.
+func (em *Emulator) PartialRefreshes() int {
+	return em.partialRefreshes
+}
+
+// Transactions is the number of WriteBytes calls, i.e. SPI transfers.
+func (em *Emulator) Transactions() int {
+	return em.transactions
+}
+
+// Errors lists protocol violations seen so far, e.g. a refresh while the
+// booster is off.
+func (em *Emulator) Errors() []string {
+	return em.errors
+}
+
+// Lut returns the waveform last written to register reg (0x20 - 0x25).
+func (em *Emulator) Lut(reg byte) []byte {
+	return em.luts[reg]
+}
+
+// Border returns the VCOM and data interval setting (0x50); bits 7:6 select
+// the border color.
+func (em *Emulator) Border() byte {
+	return em.border
+}
+
+// Temperature returns the value forced with 0xE5; ok is false until 0xE0
+// has told the controller to use it instead of its sensor.
+func (em *Emulator) Temperature() (celsius int, ok bool) {
+	return int(em.temperature), em.tsfix
+}
+
+func (em *Emulator) PoweredOn() bool {
+	return em.poweredOn
+}
+
+func (em *Emulator) Asleep() bool {
+	return em.asleep
+}
+
+func (em *Emulator) Closed() bool {
+	return em.closed
+}
+
+// Plane returns the RAM contents written with command 0x10 or 0x13.
+func (em *Emulator) Plane(cmd byte) []byte {
+	return em.ram[cmd]
+}
+
+func (em *Emulator) bit(plane []byte, x, y int) byte {
+	index := y*((em.Width+7)/8) + x/8
+	return (plane[index] >> (7 - uint(x%8))) & 0x01
+}
+
+// Image renders what the glass shows after the last refresh.
+func (em *Emulator) Image(mode RenderMode) image.Image {
+	rect := image.Rect(0, 0, em.Width, em.Height)
+	old, new := em.shown[0x10], em.shown[0x13]
+
+	var img *image.Paletted
+	switch mode {
+	case RENDER_GRAY4:
+		img = image.NewPaletted(rect, Gray4Palette)
+	case RENDER_TRICOLOR:
+		img = image.NewPaletted(rect, TriColorPalette)
+	default:
+		img = image.NewPaletted(rect, color.Palette{color.Black, color.White})
+	}
+
+	for y := 0; y < em.Height; y++ {
+		for x := 0; x < em.Width; x++ {
+			o, n := em.bit(old, x, y), em.bit(new, x, y)
+			var index uint8
+			switch mode {
+			case RENDER_GRAY4:
+				index = o<<1 | n //11 white, 10 gray1, 01 gray2, 00 black
+			case RENDER_TRICOLOR:
+				if n == 1 {
+					index = 2 //red
+				} else if o == 1 {
+					index = 1 //black
+				}
+			default:
+				index = n
+			}
+			img.SetColorIndex(x, y, index)
+		}
+	}
+	return img
+}
+
+func (em *Emulator) EncodePNG(w io.Writer, mode RenderMode) error {
+	return png.Encode(w, em.Image(mode))
+}
+
+func (em *Emulator) SavePNG(path string, mode RenderMode) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err = em.EncodePNG(f, mode); err != nil {
+		return fmt.Errorf("cannot encode png: %w", err)
+	}
+	return nil
+}
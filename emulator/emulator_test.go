@@ -0,0 +1,129 @@
+package emulator
+
+import (
+	"bytes"
+	"image/color"
+	"testing"
+
+	"periph.io/x/conn/v3/gpio"
+)
+
+func send(em *Emulator, cmd byte, data ...byte) {
+	em.Digital_writeDC(gpio.Low)
+	em.Digital_writeCS(gpio.Low)
+	em.WriteBytes([]byte{cmd})
+	em.Digital_writeCS(gpio.High)
+	if len(data) == 0 {
+		return
+	}
+	em.Digital_writeDC(gpio.High)
+	em.Digital_writeCS(gpio.Low)
+	em.WriteBytes(data)
+	em.Digital_writeCS(gpio.High)
+}
+
+func TestRefreshLatchesPlanes(t *testing.T) {
+	em := New(16, 2)
+	send(em, 0x04)
+	send(em, 0x10, 0xFF, 0xFF, 0xFF, 0xFF)
+	send(em, 0x13, 0x7F, 0xFF, 0xFF, 0xFE)
+
+	if got := em.Image(RENDER_MONO).At(0, 0); got != color.White {
+		t.Fatalf("pixel changed before refresh: %v", got)
+	}
+
+	send(em, 0x12)
+	img := em.Image(RENDER_MONO)
+	if got := img.At(0, 0); got != color.Black {
+		t.Fatalf("pixel (0,0) = %v, want black", got)
+	}
+	if got := img.At(1, 0); got != color.White {
+		t.Fatalf("pixel (1,0) = %v, want white", got)
+	}
+	if got := img.At(15, 1); got != color.Black {
+		t.Fatalf("pixel (15,1) = %v, want black", got)
+	}
+	if em.Refreshes() != 1 {
+		t.Fatalf("refreshes %d, want 1", em.Refreshes())
+	}
+}
+
+func TestGray4Levels(t *testing.T) {
+	em := New(8, 1)
+	send(em, 0x04)
+	send(em, 0x10, 0xC0) //pixels 0,1 old bit set
+	send(em, 0x13, 0xA0) //pixels 0,2 new bit set
+	send(em, 0x12)
+
+	img := em.Image(RENDER_GRAY4)
+	want := []color.Color{Gray4Palette[3], Gray4Palette[2], Gray4Palette[1], Gray4Palette[0]}
+	for x, c := range want {
+		if got := img.At(x, 0); got != c {
+			t.Fatalf("pixel %d = %v, want %v", x, got, c)
+		}
+	}
+}
+
+func TestTriColor(t *testing.T) {
+	em := New(8, 1)
+	send(em, 0x04)
+	send(em, 0x10, 0x80)
+	send(em, 0x13, 0x40)
+	send(em, 0x12)
+
+	img := em.Image(RENDER_TRICOLOR)
+	if got := img.At(0, 0); got != TriColorPalette[1] {
+		t.Fatalf("pixel 0 = %v, want black", got)
+	}
+	if got := img.At(1, 0); got != TriColorPalette[2] {
+		t.Fatalf("pixel 1 = %v, want red", got)
+	}
+	if got := img.At(2, 0); got != TriColorPalette[0] {
+		t.Fatalf("pixel 2 = %v, want white", got)
+	}
+}
+
+func TestLutBorderPowerAndSleep(t *testing.T) {
+	em := New(8, 1)
+	lut := []byte{0x00, 0x00, 0x40, 0x0A}
+	send(em, 0x20, lut...)
+	send(em, 0x50, 0xF7)
+	send(em, 0x12)
+
+	if !bytes.Equal(em.Lut(0x20), lut) {
+		t.Fatalf("lut %x, want %x", em.Lut(0x20), lut)
+	}
+	if em.Border() != 0xF7 {
+		t.Fatalf("border %#x, want 0xF7", em.Border())
+	}
+	if em.Refreshes() != 0 || len(em.Errors()) != 1 {
+		t.Fatalf("refresh while powered off was not rejected")
+	}
+
+	send(em, 0x04)
+	send(em, 0x02)
+	if em.PoweredOn() {
+		t.Fatalf("power off ignored")
+	}
+	send(em, 0x07, 0xA5)
+	if !em.Asleep() {
+		t.Fatalf("deep sleep ignored")
+	}
+
+	em.Digital_writeRST(gpio.Low)
+	em.Digital_writeRST(gpio.High)
+	if em.Asleep() {
+		t.Fatalf("reset did not wake the controller")
+	}
+}
+
+func TestEncodePNG(t *testing.T) {
+	em := New(176, 264)
+	var buf bytes.Buffer
+	if err := em.EncodePNG(&buf, RENDER_GRAY4); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() == 0 {
+		t.Fatalf("empty png")
+	}
+}
@@ -1,10 +1,10 @@
 package epd
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"image"
-	"image/color"
-	"log"
 	"time"
 
 	"github.com/mipsmonsta/epd/epd_config"
@@ -12,618 +12,831 @@ import (
 	"periph.io/x/conn/v3/gpio"
 )
 
+// Resolution of the 2.7" panel, the default model. Other models carry their
+// own size in Panel.
 const (
 	EPD_WIDTH  int = 176
 	EPD_HEIGHT int = 264
 )
 
-var (
-	lut_vcom_dc = []byte{
-		0x00, 0x00,
-		0x00, 0x1A, 0x1A, 0x00, 0x00, 0x01,
-		0x00, 0x0A, 0x0A, 0x00, 0x00, 0x08,
-		0x00, 0x0E, 0x01, 0x0E, 0x01, 0x10,
-		0x00, 0x0A, 0x0A, 0x00, 0x00, 0x08,
-		0x00, 0x04, 0x10, 0x00, 0x00, 0x05,
-		0x00, 0x03, 0x0E, 0x00, 0x00, 0x0A,
-		0x00, 0x23, 0x00, 0x00, 0x00, 0x01,
-	}
-
-	lut_ww = []byte{
-		0x90, 0x1A, 0x1A, 0x00, 0x00, 0x01,
-		0x40, 0x0A, 0x0A, 0x00, 0x00, 0x08,
-		0x84, 0x0E, 0x01, 0x0E, 0x01, 0x10,
-		0x80, 0x0A, 0x0A, 0x00, 0x00, 0x08,
-		0x00, 0x04, 0x10, 0x00, 0x00, 0x05,
-		0x00, 0x03, 0x0E, 0x00, 0x00, 0x0A,
-		0x00, 0x23, 0x00, 0x00, 0x00, 0x01,
-	}
-
-	// R22H    r
-	lut_bw = []byte{
-		0xA0, 0x1A, 0x1A, 0x00, 0x00, 0x01,
-		0x00, 0x0A, 0x0A, 0x00, 0x00, 0x08,
-		0x84, 0x0E, 0x01, 0x0E, 0x01, 0x10,
-		0x90, 0x0A, 0x0A, 0x00, 0x00, 0x08,
-		0xB0, 0x04, 0x10, 0x00, 0x00, 0x05,
-		0xB0, 0x03, 0x0E, 0x00, 0x00, 0x0A,
-		0xC0, 0x23, 0x00, 0x00, 0x00, 0x01,
-	}
-
-	// R23H    w
-	lut_bb = []byte{
-		0x90, 0x1A, 0x1A, 0x00, 0x00, 0x01,
-		0x40, 0x0A, 0x0A, 0x00, 0x00, 0x08,
-		0x84, 0x0E, 0x01, 0x0E, 0x01, 0x10,
-		0x80, 0x0A, 0x0A, 0x00, 0x00, 0x08,
-		0x00, 0x04, 0x10, 0x00, 0x00, 0x05,
-		0x00, 0x03, 0x0E, 0x00, 0x00, 0x0A,
-		0x00, 0x23, 0x00, 0x00, 0x00, 0x01,
-	}
-	// R24H    b
-	lut_wb = []byte{
-		0x90, 0x1A, 0x1A, 0x00, 0x00, 0x01,
-		0x20, 0x0A, 0x0A, 0x00, 0x00, 0x08,
-		0x84, 0x0E, 0x01, 0x0E, 0x01, 0x10,
-		0x10, 0x0A, 0x0A, 0x00, 0x00, 0x08,
-		0x00, 0x04, 0x10, 0x00, 0x00, 0x05,
-		0x00, 0x03, 0x0E, 0x00, 0x00, 0x0A,
-		0x00, 0x23, 0x00, 0x00, 0x00, 0x01,
-	}
-
-	//0-3 gray
-	gray_lut_vcom = []byte{
-		0x00, 0x00,
-		0x00, 0x0A, 0x00, 0x00, 0x00, 0x01,
-		0x60, 0x14, 0x14, 0x00, 0x00, 0x01,
-		0x00, 0x14, 0x00, 0x00, 0x00, 0x01,
-		0x00, 0x13, 0x0A, 0x01, 0x00, 0x01,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-	}
-
-	//R21
-	gray_lut_ww = []byte{
-		0x40, 0x0A, 0x00, 0x00, 0x00, 0x01,
-		0x90, 0x14, 0x14, 0x00, 0x00, 0x01,
-		0x10, 0x14, 0x0A, 0x00, 0x00, 0x01,
-		0xA0, 0x13, 0x01, 0x00, 0x00, 0x01,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-	}
-	//R22H	r
-	gray_lut_bw = []byte{
-		0x40, 0x0A, 0x00, 0x00, 0x00, 0x01,
-		0x90, 0x14, 0x14, 0x00, 0x00, 0x01,
-		0x00, 0x14, 0x0A, 0x00, 0x00, 0x01,
-		0x99, 0x0C, 0x01, 0x03, 0x04, 0x01,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-	}
-
-	//R23H	w
-	gray_lut_wb = []byte{
-		0x40, 0x0A, 0x00, 0x00, 0x00, 0x01,
-		0x90, 0x14, 0x14, 0x00, 0x00, 0x01,
-		0x00, 0x14, 0x0A, 0x00, 0x00, 0x01,
-		0x99, 0x0B, 0x04, 0x04, 0x01, 0x01,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-	}
-	//R24H	b
-	gray_lut_bb = []byte{
-		0x80, 0x0A, 0x00, 0x00, 0x00, 0x01,
-		0x90, 0x14, 0x14, 0x00, 0x00, 0x01,
-		0x20, 0x14, 0x0A, 0x00, 0x00, 0x01,
-		0x50, 0x13, 0x01, 0x00, 0x00, 0x01,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
-	}
-)
-
 type Mode int
 
 const (
 	MODE_MONO_DITHER_ON  Mode = 0
 	MODE_MONO_DITHER_OFF Mode = 1
+	MODE_TRICOLOR        Mode = 2 //black, white and red on panels with COLOR_TRICOLOR
 )
 
+var (
+	ErrUnknownMode    = errors.New("unknown mode")
+	ErrInvalidWindow  = errors.New("partial window is empty or outside the panel")
+	ErrUnknownRefresh = errors.New("unknown refresh")
+	ErrBufferSize     = errors.New("buffer length does not match the panel")
+	ErrBusyTimeout    = errors.New("panel still busy")
+)
+
+// DEFAULT_BUSY_TIMEOUT is how long ReadBusy waits when Epd.BusyTimeout is
+// zero, well past the slowest 4 gray refresh.
+const DEFAULT_BUSY_TIMEOUT = 30 * time.Second
+
 type Epd struct {
-	Config epd_config.EpdConfig
-}
+	Config epd_config.Transport // e.g. &epd_config.EpdConfig{} on the Pi
+	Panel  *Panel               // model being driven; nil means Panel_2in7
+
+	RedDetector RedDetector // picks red pixels in MODE_TRICOLOR; nil means DefaultRedDetector
+
+	TemperatureBands  []TemperatureBand       // waveform per temperature range; nil means DefaultTemperatureBands
+	TemperatureSensor func() (float64, error) // ambient reading in °C, polled before each refresh; nil to set it by hand
+
+	AutoWake bool // Display and Clear wake a powered off or sleeping panel instead of failing
+
+	Rotation Rotation // clockwise turn applied to images before fitting; ROTATE_AUTO guesses from their shape
+
+	Ghosting GhostingPolicy // when fast and partial updates give way to a full refresh
+
+	BusyTimeout time.Duration // longest wait for BUSY to clear; zero means DEFAULT_BUSY_TIMEOUT
+
+	state State //see lifecycle.go
+	mode  State //ready state Setup or Setup_4Gray left, for Wake
 
-func (e *Epd) Reset() {
-	e.Config.Digital_writeRST(gpio.High)
-	time.Sleep(200 * time.Millisecond)
-	e.Config.Digital_writeRST(gpio.Low)
-	time.Sleep(5 * time.Millisecond)
-	e.Config.Digital_writeRST(gpio.High)
-	time.Sleep(200 * time.Millisecond)
+	loadedLut  WaveformSlot               //waveform in the LUT registers; lutUnknown after reset
+	waveforms  map[WaveformSlot]*Waveform //set by SetWaveform, replacing the panel's tables
+	prevMono   []byte                     //last mono frame sent, the old plane of a fast refresh; nil if unknown
+	prevGray4  []byte                     //last 4 gray frame sent; nil if unknown
+	lastUpdate Update
+
+	temperature    float64 //last SetTemperature value, sent again after a reset
+	hasTemperature bool
+	bandLuts       map[WaveformSlot]LutSet //tables of the current temperature band
+
+	ghost GhostingStats
+	clock func() time.Time //replaced in tests to step the ghosting timer
+
+	sleep func(time.Duration) //replaced in tests to skip hardware delays
 }
 
-func (e *Epd) Send_command(command byte) {
-	e.Config.Digital_writeDC(gpio.Low)
-	e.Config.Digital_writeCS(gpio.Low)
-	e.Config.WriteBytes([]byte{command})
-	e.Config.Digital_writeCS(gpio.High)
+// NewEpd returns a driver for the registered model, e.g. MODEL_2IN7.
+func NewEpd(config epd_config.Transport, model string) (*Epd, error) {
+	p, err := LookupPanel(model)
+	if err != nil {
+		return nil, err
+	}
+	return &Epd{Config: config, Panel: p}, nil
 }
 
-func (e *Epd) Send_data(data byte) {
-	e.Config.Digital_writeDC(gpio.High)
-	e.Config.Digital_writeCS(gpio.Low)
-	e.Config.WriteBytes([]byte{data})
-	e.Config.Digital_writeCS(gpio.High)
+func (e *Epd) panel() *Panel {
+	if e.Panel == nil {
+		return Panel_2in7
+	}
+	return e.Panel
 }
 
-func (e *Epd) ReadBusy() {
-	for e.Config.Digital_readBS() == gpio.Low { //low is idle; 1 is busy
-		time.Sleep(200 * time.Millisecond)
+func (e *Epd) delay(d time.Duration) {
+	if e.sleep != nil {
+		e.sleep(d)
+		return
 	}
+	time.Sleep(d)
 }
 
-func (e *Epd) Set_lut() {
-	e.Send_command(0x20) //vcom
-	for count := 0; count < 44; count++ {
-		e.Send_data(lut_vcom_dc[count])
+func (e *Epd) Reset() error {
+	for _, step := range []struct {
+		level gpio.Level
+		wait  time.Duration
+	}{
+		{gpio.High, 200 * time.Millisecond},
+		{gpio.Low, 5 * time.Millisecond},
+		{gpio.High, 200 * time.Millisecond},
+	} {
+		if err := e.Config.Digital_writeRST(step.level); err != nil {
+			return fmt.Errorf("reset: %w", err)
+		}
+		e.delay(step.wait)
 	}
-	e.Send_command(0x21) //ww --
-	for count := 0; count < 42; count++ {
-		e.Send_data(lut_ww[count])
+	return nil
+}
+
+func (e *Epd) Send_command(command byte) error {
+	if err := e.Config.Digital_writeDC(gpio.Low); err != nil {
+		return fmt.Errorf("command %#02x: %w", command, err)
 	}
-	e.Send_command(0x22) //bw r
-	for count := 0; count < 42; count++ {
-		e.Send_data(lut_bw[count])
+	if err := e.Config.Digital_writeCS(gpio.Low); err != nil {
+		return fmt.Errorf("command %#02x: %w", command, err)
 	}
-	e.Send_command(0x23) //wb w
-	for count := 0; count < 42; count++ {
-		e.Send_data(lut_bb[count])
+	if err := e.Config.WriteBytes([]byte{command}); err != nil {
+		return fmt.Errorf("command %#02x: %w", command, err)
 	}
-	e.Send_command(0x24) //bb b
-	for count := 0; count < 42; count++ {
-		e.Send_data(lut_wb[count])
+	if err := e.Config.Digital_writeCS(gpio.High); err != nil {
+		return fmt.Errorf("command %#02x: %w", command, err)
 	}
+	return nil
 }
 
-func (e *Epd) Gray_SetLut() {
-	e.Send_command(0x20)
-	for count := 0; count < 44; count++ {
-		e.Send_data(gray_lut_vcom[count])
+func (e *Epd) Send_data(data byte) error {
+	if err := e.Config.Digital_writeDC(gpio.High); err != nil {
+		return fmt.Errorf("data: %w", err)
 	}
-
-	e.Send_command(0x21)
-	for count := 0; count < 42; count++ { //red not use
-		e.Send_data(gray_lut_ww[count])
+	if err := e.Config.Digital_writeCS(gpio.Low); err != nil {
+		return fmt.Errorf("data: %w", err)
 	}
-
-	e.Send_command(0x22)
-	for count := 0; count < 42; count++ { //bw r
-		e.Send_data(gray_lut_bw[count])
+	if err := e.Config.WriteBytes([]byte{data}); err != nil {
+		return fmt.Errorf("data: %w", err)
 	}
-
-	e.Send_command(0x23) //wb w
-	for count := 0; count < 42; count++ {
-		e.Send_data(gray_lut_wb[count])
+	if err := e.Config.Digital_writeCS(gpio.High); err != nil {
+		return fmt.Errorf("data: %w", err)
 	}
+	return nil
+}
 
-	e.Send_command(0x24) //bb b
-	for count := 0; count < 42; count++ {
-		e.Send_data(gray_lut_bb[count])
+func (e *Epd) maxTxSize() int {
+	if l, ok := e.Config.(epd_config.TxLimiter); ok && l.MaxTxSize() > 0 {
+		return l.MaxTxSize()
 	}
+	return epd_config.DEFAULT_MAX_TX_SIZE
+}
 
-	e.Send_command((0x25)) //vcom
-	for count := 0; count < 42; count++ {
-		e.Send_data(gray_lut_ww[count])
+// Send_data_bulk sends data with CS held low, split into transfers no
+// larger than the transport's MaxTxSize.
+func (e *Epd) Send_data_bulk(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := e.Config.Digital_writeDC(gpio.High); err != nil {
+		return fmt.Errorf("data: %w", err)
+	}
+	if err := e.Config.Digital_writeCS(gpio.Low); err != nil {
+		return fmt.Errorf("data: %w", err)
 	}
+	chunk := e.maxTxSize()
+	for start := 0; start < len(data); start += chunk {
+		end := start + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		if err := e.Config.WriteBytes(data[start:end]); err != nil {
+			e.Config.Digital_writeCS(gpio.High)
+			return fmt.Errorf("data: %w", err)
+		}
+	}
+	if err := e.Config.Digital_writeCS(gpio.High); err != nil {
+		return fmt.Errorf("data: %w", err)
+	}
+	return nil
 }
 
-func (e *Epd) Setup() {
-	e.Config.Setup()
-
-	e.Reset()
-
-	e.Send_command(0x01) // POWER_SETTING
-	e.Send_data(0x03)    // VDS_EN, VDG_EN
-	e.Send_data(0x00)    // VCOM_HV, VGHL_LV[1], VGHL_LV[0]
-	e.Send_data(0x2b)    // VDH
-	e.Send_data(0x2b)    // VDL
-	e.Send_data(0x09)    // VDHR
-
-	e.Send_command(0x06) // BOOSTER_SOFT_START
-	e.Send_data(0x07)
-	e.Send_data(0x07)
-	e.Send_data(0x17)
-
-	// Power optimization
-	e.Send_command(0xF8)
-	e.Send_data(0x60)
-	e.Send_data(0xA5)
-
-	// Power optimization
-	e.Send_command(0xF8)
-	e.Send_data(0x89)
-	e.Send_data(0xA5)
-
-	// Power optimization
-	e.Send_command(0xF8)
-	e.Send_data(0x90)
-	e.Send_data(0x00)
-
-	// Power optimization
-	e.Send_command(0xF8)
-	e.Send_data(0x93)
-	e.Send_data(0x2A)
-
-	// Power optimization
-	e.Send_command(0xF8)
-	e.Send_data(0xA0)
-	e.Send_data(0xA5)
-
-	// Power optimization
-	e.Send_command(0xF8)
-	e.Send_data(0xA1)
-	e.Send_data(0x00)
-
-	// Power optimization
-	e.Send_command(0xF8)
-	e.Send_data(0x73)
-	e.Send_data(0x41)
-
-	e.Send_command(0x16) // PARTIAL_DISPLAY_REFRESH
-	e.Send_data(0x00)
-	e.Send_command(0x04) // POWER_ON
-	e.ReadBusy()
-
-	e.Send_command(0x00) // PANEL_SETTING
-	e.Send_data(0xAF)    // KW-BF   KWR-AF    BWROTP 0f
-
-	e.Send_command(0x30) // PLL_CONTROL
-	e.Send_data(0x3A)    // 3A 100HZ   29 150Hz 39 200HZ    31 171HZ
-
-	e.Send_command(0x50) // VCOM AND DATA INTERVAL SETTING
-	e.Send_data(0x57)
-
-	e.Send_command(0x82) // VCM_DC_SETTING_REGISTER
-	e.Send_data(0x12)
-	e.Set_lut()
+// send writes a command followed by its parameter bytes.
+func (e *Epd) send(command byte, data ...byte) error {
+	if err := e.Send_command(command); err != nil {
+		return err
+	}
+	if err := e.Send_data_bulk(data); err != nil {
+		return fmt.Errorf("command %#02x: %w", command, err)
+	}
+	return nil
 }
 
-func (e *Epd) Setup_4Gray() {
-	e.Config.Setup()
-
-	e.Reset()
-
-	e.Send_command(0x01) //POWER SETTING
-	e.Send_data(0x03)
-	e.Send_data(0x00)
-	e.Send_data(0x2b)
-	e.Send_data(0x2b)
-
-	e.Send_command(0x06) //booster soft start
-	e.Send_data(0x07)    //A
-	e.Send_data(0x07)    //B
-	e.Send_data(0x17)    //C
-
-	e.Send_command(0xF8) //boost??
-	e.Send_data(0x60)
-	e.Send_data(0xA5)
-
-	e.Send_command(0xF8) //boost??
-	e.Send_data(0x89)
-	e.Send_data(0xA5)
-
-	e.Send_command(0xF8) //boost??
-	e.Send_data(0x90)
-	e.Send_data(0x00)
-
-	e.Send_command(0xF8) //boost??
-	e.Send_data(0x93)
-	e.Send_data(0x2A)
-
-	e.Send_command(0xF8) //boost??
-	e.Send_data(0xa0)
-	e.Send_data(0xa5)
-
-	e.Send_command(0xF8) //boost??
-	e.Send_data(0xa1)
-	e.Send_data(0x00)
+// runSequence sends cmds in order, waiting for BUSY where asked.
+func (e *Epd) runSequence(cmds []Command) error {
+	for _, c := range cmds {
+		if err := e.send(c.Cmd, c.Data...); err != nil {
+			return err
+		}
+		if c.WaitBusy {
+			if err := e.ReadBusy(); err != nil {
+				return fmt.Errorf("command %#02x: %w", c.Cmd, err)
+			}
+		}
+	}
+	return nil
+}
 
-	e.Send_command(0xF8) //boost??
-	e.Send_data(0x73)
-	e.Send_data(0x41)
+func (e *Epd) uploadLut(luts LutSet) error {
+	for _, l := range luts {
+		if err := e.send(l.Register, l.Data...); err != nil {
+			return fmt.Errorf("lut: %w", err)
+		}
+	}
+	return nil
+}
 
-	e.Send_command(0x16)
-	e.Send_data(0x00)
+// ReadBusy waits for the controller to go idle, polling BUSY every 200ms.
+// It returns ErrBusyTimeout once BusyTimeout has passed, e.g. when the panel
+// is unplugged or the pin is wired wrong.
+func (e *Epd) ReadBusy() error {
+	timeout := e.BusyTimeout
+	if timeout <= 0 {
+		timeout = DEFAULT_BUSY_TIMEOUT
+	}
+	const poll = 200 * time.Millisecond
+	for waited := time.Duration(0); e.Config.Digital_readBS() == e.panel().BusyLevel; waited += poll {
+		if waited >= timeout {
+			return fmt.Errorf("%w after %v", ErrBusyTimeout, timeout)
+		}
+		e.delay(poll)
+	}
+	return nil
+}
 
-	e.Send_command(0x04)
-	e.ReadBusy()
+func (e *Epd) Set_lut() error {
+	if err := e.uploadLut(e.lut(WAVEFORM_FULL)); err != nil {
+		return err
+	}
+	e.loadedLut = WAVEFORM_FULL
+	return nil
+}
 
-	e.Send_command(0x00) //panel setting
-	e.Send_data(0xbf)    //KW-BF   KWR-AF	BWROTP 0f
+// useLut uploads the waveform for r unless it is already loaded.
+func (e *Epd) useLut(r Refresh) error {
+	p := e.panel()
+	switch r {
+	case REFRESH_FULL:
+		if e.loadedLut == WAVEFORM_FULL {
+			return nil
+		}
+		return e.Set_lut()
+	case REFRESH_FAST:
+		if len(e.lut(WAVEFORM_FAST)) == 0 {
+			return fmt.Errorf("fast refresh on %s: %w", p.Name, ErrUnsupported)
+		}
+		if e.loadedLut == WAVEFORM_FAST {
+			return nil
+		}
+		if err := e.uploadLut(e.lut(WAVEFORM_FAST)); err != nil {
+			return err
+		}
+		e.loadedLut = WAVEFORM_FAST
+		return nil
+	}
+	return fmt.Errorf("%w: %d", ErrUnknownRefresh, r)
+}
 
-	e.Send_command(0x30) //PLL setting
-	e.Send_data(0x90)    //100hz
+func (e *Epd) Gray_SetLut() error {
+	p := e.panel()
+	if !p.Supports(COLOR_GRAY4) {
+		return fmt.Errorf("4 gray on %s: %w", p.Name, ErrUnsupported)
+	}
+	if err := e.uploadLut(e.lut(WAVEFORM_GRAY4)); err != nil {
+		return err
+	}
+	e.loadedLut = WAVEFORM_GRAY4
+	return nil
+}
 
-	e.Send_command(0x61) //resolution setting
-	e.Send_data(0x00)    //176
-	e.Send_data(0xb0)
-	e.Send_data(0x01) //264
-	e.Send_data(0x08)
+func (e *Epd) Setup() error {
+	return e.setup(STATE_MONO_READY)
+}
 
-	e.Send_command(0x82) //vcom_DC setting
-	e.Send_data(0x12)
+// setup opens the transport and initialises the panel for mode; it can be
+// called in any state, including after Close.
+func (e *Epd) setup(mode State) error {
+	e.state, e.mode, e.prevMono, e.prevGray4 = STATE_UNINITIALISED, mode, nil, nil
+	e.ghost = GhostingStats{LastFull: e.now()} //MaxAge counts from Setup until the first full refresh
+	if err := e.Config.Setup(); err != nil {
+		return fmt.Errorf("setup: %w", err)
+	}
+	if err := e.initialise(mode); err != nil {
+		e.state = STATE_UNINITIALISED
+		return err
+	}
+	return nil
+}
 
-	e.Send_command(0x50) ///VCOM AND DATA INTERVAL SETTING
-	e.Send_data(0x57)
+// restoreTemperature programs the temperature again after a reset, taking
+// a fresh reading when there is a sensor.
+func (e *Epd) restoreTemperature() error {
+	if e.TemperatureSensor != nil {
+		e.hasTemperature = false
+		return e.UpdateTemperature()
+	}
+	if e.hasTemperature {
+		return e.SetTemperature(e.temperature)
+	}
+	return nil
+}
 
+func (e *Epd) Setup_4Gray() error {
+	p := e.panel()
+	if !p.Supports(COLOR_GRAY4) {
+		return fmt.Errorf("4 gray on %s: %w", p.Name, ErrUnsupported)
+	}
+	return e.setup(STATE_GRAY_READY)
 }
 
-func (e *Epd) Clear() {
-	e.Send_command(0x10)
-	for i := 0; i < EPD_WIDTH*EPD_HEIGHT/8; i++ {
-		e.Send_data(0xFF)
+// sendPlane writes count copies of fill, or the bytes of buf when buf is
+// not nil, as the data of command.
+func (e *Epd) sendPlane(command byte, buf []byte, fill byte, count int) error {
+	if buf == nil {
+		buf = make([]byte, count)
+		for i := range buf {
+			buf[i] = fill
+		}
+	}
+	if err := e.Send_command(command); err != nil {
+		return err
 	}
-	e.Send_command(0x13)
-	for i := 0; i < EPD_WIDTH*EPD_HEIGHT/8; i++ {
-		e.Send_data(0xFF)
+	if err := e.Send_data_bulk(buf[:count]); err != nil {
+		return fmt.Errorf("plane %#02x: %w", command, err)
 	}
-	e.Send_command(0x12)
-	e.ReadBusy()
+	return nil
+}
 
+// sendFrame writes both RAM planes; a nil plane is filled with white.
+func (e *Epd) sendFrame(oldPlane, newPlane []byte) error {
+	p := e.panel()
+	if err := e.runSequence(p.FrameSetup); err != nil {
+		return err
+	}
+	if p.OldDataCommand != 0 {
+		if err := e.sendPlane(p.OldDataCommand, oldPlane, p.WhiteByte(), p.MonoBufferSize()); err != nil {
+			return err
+		}
+	}
+	return e.sendPlane(p.NewDataCommand, newPlane, p.WhiteByte(), p.MonoBufferSize())
 }
 
-func (e *Epd) Sleep() {
-	e.Send_command(0x50)
-	e.Send_data(0xF7)
-	e.Send_command(0x02)
-	e.Send_command(0x07)
-	e.Send_data(0xA5)
+func (e *Epd) refresh() error {
+	return e.runSequence(e.panel().Refresh)
+}
 
-	time.Sleep(2000 * time.Millisecond)
-	e.Config.Destroy()
+func (e *Epd) Clear() error {
+	if err := e.ready(STATE_UNINITIALISED); err != nil {
+		return err
+	}
+	if err := e.UpdateTemperature(); err != nil {
+		return err
+	}
+	if e.state == STATE_MONO_READY {
+		if err := e.useLut(REFRESH_FULL); err != nil {
+			return err
+		}
+	}
+	if err := e.sendFrame(nil, nil); err != nil {
+		return err
+	}
+	p := e.panel()
+	e.prevMono = bytes.Repeat([]byte{p.WhiteByte()}, p.MonoBufferSize())
+	e.prevGray4 = bytes.Repeat([]byte{0xFF}, p.Gray4BufferSize())
+	e.lastUpdate = Update{Changed: image.Rect(0, 0, p.Width, p.Height)}
+	if err := e.refresh(); err != nil {
+		return err
+	}
+	e.noteFullRefresh()
+	return nil
 }
 
-func (e *Epd) Display(img *image.Image, mode Mode) {
-	orientAndfittedImage := imageutil.OrientateAndFitImage(img, EPD_WIDTH, EPD_HEIGHT)
+// Display rotates img by e.Rotation, fits it to the panel, converts it for mode and shows it. opts
+// apply to this call only, e.g. WithRefresh(REFRESH_FAST).
+func (e *Epd) Display(img *image.Image, mode Mode, opts ...DisplayOption) error {
+	p := e.panel()
+	o := newDisplayOptions(opts)
+	orientAndfittedImage, err := e.fit(img, o)
+	if err != nil {
+		return err
+	}
+
+	if mode == MODE_TRICOLOR {
+		if o.refresh != REFRESH_FULL {
+			return fmt.Errorf("tri-color with refresh %d: %w", o.refresh, ErrUnsupported)
+		}
+		return e.displayTriColor(&orientAndfittedImage)
+	}
+	orientAndfittedImage = o.adjust(orientAndfittedImage)
 
 	var monochromeTensor [][]uint8
 	switch mode {
 	case MODE_MONO_DITHER_ON:
-		monochromeTensor = ConvertImagetoMonochromeEPDTensorWithDither(&orientAndfittedImage)
+		monochromeTensor, err = o.monochrome(&orientAndfittedImage, true)
 	case MODE_MONO_DITHER_OFF:
-		monochromeTensor = ConvertImagetoMonochromeEPDTensor(&orientAndfittedImage)
+		monochromeTensor, err = o.monochrome(&orientAndfittedImage, false)
 	default:
-		log.Fatalf("Unknown mode \n")
+		return fmt.Errorf("%w: %d", ErrUnknownMode, mode)
 	}
-	monochromeBslices := GetEPDBuffer(monochromeTensor)
-	e.Send_command(0x10)
+	if err != nil {
+		return err
+	}
+
+	return e.displayMono(p.GetEPDBuffer(monochromeTensor), o)
+}
 
-	for i := 0; i < EPD_HEIGHT*EPD_WIDTH/8; i++ {
-		e.Send_data(0xFF)
+// displayMono sends a packed mono buffer in the panel's bit polarity and
+// refreshes with the waveform picked in o.
+func (e *Epd) displayMono(buf []byte, o displayOptions) error {
+	p := e.panel()
+	if err := e.ready(STATE_MONO_READY); err != nil {
+		return err
+	}
+	changed := p.changedMono(e.prevMono, buf)
+	e.lastUpdate = Update{Changed: changed, Refresh: o.refresh, Skipped: !o.force && changed.Empty()}
+	if e.lastUpdate.Skipped {
+		return nil
 	}
-	e.Send_command(0x13)
-	for i := 0; i < EPD_HEIGHT*EPD_WIDTH/8; i++ {
-		e.Send_data(monochromeBslices[i])
+	if err := e.UpdateTemperature(); err != nil {
+		return err
 	}
-	e.Send_command(0x12)
-	e.ReadBusy()
+	if o.refresh != REFRESH_FULL && e.ghostingDue() {
+		if err := e.forceFull(); err != nil {
+			return err
+		}
+		o.refresh = REFRESH_FULL
+		e.lastUpdate.Refresh, e.lastUpdate.Forced = REFRESH_FULL, true
+	}
+	if o.refresh == REFRESH_PARTIAL {
+		window, err := p.alignWindow(changed)
+		if p.PartialRefresh && e.prevMono != nil && err == nil {
+			if err := e.useLut(REFRESH_FULL); err != nil {
+				return err
+			}
+			e.lastUpdate.Partial = true
+			return e.sendPartial(buf, window)
+		}
+		o.refresh = REFRESH_FULL
+		e.lastUpdate.Refresh = REFRESH_FULL
+	}
+	if err := e.useLut(o.refresh); err != nil {
+		return err
+	}
+	var err error
+	switch {
+	case p.monoDataCommand() == p.OldDataCommand:
+		err = e.sendFrame(buf, nil)
+	case o.refresh == REFRESH_FAST && len(e.prevMono) == len(buf):
+		//the fast waveform only drives pixels that changed
+		err = e.sendFrame(e.prevMono, buf)
+	default:
+		err = e.sendFrame(nil, buf)
+	}
+	if err != nil {
+		return err
+	}
+	e.prevMono = append(e.prevMono[:0], buf...)
+	e.prevGray4 = nil
+	if err := e.refresh(); err != nil {
+		return err
+	}
+	if o.refresh == REFRESH_FULL {
+		e.noteFullRefresh()
+	} else {
+		e.noteUpdate()
+	}
+	return nil
+}
 
+// DisplayMonoFrame shows f as is; its bounds, stride and Pix must be the
+// panel's portrait size, or ErrFrameSize is returned.
+func (e *Epd) DisplayMonoFrame(f *MonoFrame, opts ...DisplayOption) error {
+	p := e.panel()
+	if f.Rect.Dx() != p.Width || f.Rect.Dy() != p.Height || f.Stride != p.Stride() {
+		return fmt.Errorf("%w: mono frame %v on %s", ErrFrameSize, f.Rect.Size(), p.Name)
+	}
+	if len(f.Pix) < p.MonoBufferSize() {
+		return fmt.Errorf("%w: mono frame of %d bytes, %s takes %d", ErrFrameSize, len(f.Pix), p.Name, p.MonoBufferSize())
+	}
+	buf := f.Pix[:p.MonoBufferSize()]
+	if p.InvertBits {
+		buf = make([]byte, len(buf))
+		for i, b := range f.Pix[:len(buf)] {
+			buf[i] = ^b
+		}
+	}
+	return e.displayMono(buf, newDisplayOptions(opts))
 }
 
-func (e *Epd) Display_4Gray(img *image.Image) {
-	orientAndfittedImage := imageutil.OrientateAndFitImage(img, EPD_WIDTH, EPD_HEIGHT)
+// DisplayMonoBuffer shows a frame already packed the way GetEPDBuffer packs
+// it for this panel, skipping all image processing. Its length must be
+// MonoBufferSize.
+func (e *Epd) DisplayMonoBuffer(buf []byte, opts ...DisplayOption) error {
+	p := e.panel()
+	if len(buf) != p.MonoBufferSize() {
+		return fmt.Errorf("%w: mono buffer of %d bytes, %s takes %d", ErrBufferSize, len(buf), p.Name, p.MonoBufferSize())
+	}
+	return e.displayMono(buf, newDisplayOptions(opts))
+}
 
-	grayTensor := ConvertImageto4GrayEPDTensor(&orientAndfittedImage)
+func (e *Epd) displayTriColor(img *image.Image) error {
+	p := e.panel()
+	if !p.Supports(COLOR_TRICOLOR) {
+		return fmt.Errorf("tri-color on %s: %w", p.Name, ErrUnsupported)
+	}
+	black, red, err := ConvertImagetoTriColorEPDTensors(img, e.RedDetector)
+	if err != nil {
+		return err
+	}
+	if err := e.ready(STATE_MONO_READY); err != nil {
+		return err
+	}
+	if err := e.UpdateTemperature(); err != nil {
+		return err
+	}
+	if err := e.sendFrame(p.GetEPDBuffer(black), p.GetEPDBuffer(red)); err != nil {
+		return err
+	}
+	e.prevMono, e.prevGray4 = nil, nil
+	e.lastUpdate = Update{Changed: image.Rect(0, 0, p.Width, p.Height)}
+	if err := e.refresh(); err != nil {
+		return err
+	}
+	e.noteFullRefresh()
+	return nil
+}
 
-	grayBslices := GetEPDBuffer_4Gray(grayTensor)
-	// for i := 0; i < EPD_HEIGHT*EPD_WIDTH/4; i++ {
-	// 	temp1 := grayBslices[i]
-	// 	fmt.Printf("%b\n", temp1)
+// windowParams encodes a partial window as the x, y, w, l parameters of
+// commands 0x14, 0x15 and 0x16; x and w are multiples of 8.
+func windowParams(r image.Rectangle) []byte {
+	x, y, w, l := r.Min.X, r.Min.Y, r.Dx(), r.Dy()
+	return []byte{
+		byte(x >> 8), byte(x & 0xf8),
+		byte(y >> 8), byte(y & 0xff),
+		byte(w >> 8), byte(w & 0xf8),
+		byte(l >> 8), byte(l & 0xff),
+	}
+}
 
-	// }
-	e.Send_command(0x10)
-	for i := 0; i < EPD_HEIGHT*EPD_WIDTH/8; i++ {
-		var temp3 uint8
-		for j := 0; j < 2; j++ {
-			temp1 := grayBslices[i*2+j]
-			for k := 0; k < 2; k++ {
-				temp2 := temp1 & 0xC0 //0xC0 is 1100 0000
-				// fmt.Printf("Pass 1 i:%v, j:%v, k:%v, i*2+j:%v, temp1:%b\n", i, j, k, i*2+j, temp1)
-				if temp2 == 0xC0 {
-					temp3 |= 0x01 //white
-				} else if temp2 == 0x00 {
-					temp3 |= 0x00 //black
-				} else if temp2 == 0x80 {
-					temp3 |= 0x01 //gray1
-				} else { //0x40
-					temp3 |= 0x00 //gray2
-				}
-				temp3 <<= 1
-				temp1 <<= 2
-				temp2 = temp1 & 0xC0
-				if temp2 == 0xC0 { //white
-					temp3 |= 0x01
-				} else if temp2 == 0x00 { //black
-					temp3 |= 0x00
-				} else if temp2 == 0x80 {
-					temp3 |= 0x01 //gray1
-				} else { //0x40
-					temp3 |= 0x00 //gray2
-				}
-				if j != 1 || k != 1 {
-					temp3 <<= 1
-				}
-				temp1 <<= 2
-			}
-		}
+// alignWindow clips r to the panel and widens it to whole bytes, because the
+// controller ignores the low 3 bits of x and w.
+func (p *Panel) alignWindow(r image.Rectangle) (image.Rectangle, error) {
+	r = r.Intersect(image.Rect(0, 0, p.Width, p.Height))
+	if r.Empty() {
+		return r, ErrInvalidWindow
+	}
+	r.Min.X = r.Min.X / 8 * 8
+	r.Max.X = (r.Max.X + 7) / 8 * 8
+	return r, nil
+}
 
-		e.Send_data(temp3)
+// cropBuffer copies the bytes of window r out of a full-frame mono buffer.
+func (p *Panel) cropBuffer(buf []byte, r image.Rectangle) []byte {
+	stride := p.Stride()
+	out := make([]byte, 0, r.Dx()/8*r.Dy())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		out = append(out, buf[y*stride+r.Min.X/8:y*stride+r.Max.X/8]...)
 	}
+	return out
+}
 
-	e.Send_command(0x13)
-	for i := 0; i < EPD_HEIGHT*EPD_WIDTH/8; i++ {
-		var temp3 uint8
-		for j := 0; j < 2; j++ {
-			temp1 := grayBslices[i*2+j]
-			for k := 0; k < 2; k++ {
-				temp2 := temp1 & 0xC0 //0xC0 is 1100 0000
-				if temp2 == 0xC0 {
-					temp3 |= 0x01 //white
-				} else if temp2 == 0x00 {
-					temp3 |= 0x00 //black
-				} else if temp2 == 0x80 {
-					temp3 |= 0x00 //gray1
-				} else { //0x40
-					temp3 |= 0x01 //gray2
-				}
-				temp3 <<= 1
-				temp1 <<= 2
-				temp2 = temp1 & 0xC0
-				if temp2 == 0xC0 { //white
-					temp3 |= 0x01
-				} else if temp2 == 0x00 { //black
-					temp3 |= 0x00
-				} else if temp2 == 0x80 {
-					temp3 |= 0x00 //gray1
-				} else { //0x40
-					temp3 |= 0x01 //gray2
-				}
-				if j != 1 || k != 1 {
-					temp3 <<= 1
-				}
-				temp1 <<= 2
-			}
+// DisplayPartial sends only the pixels of img inside r and refreshes that
+// window, leaving the rest of the panel untouched. r is in panel (portrait)
+// coordinates and is widened to multiples of 8 pixels horizontally. img
+// goes through the same orientation, fitting and thresholding as Display.
+// When the frame on screen is known the window shrinks to the pixels that
+// changed, and nothing is sent if none did unless WithForce is given.
+// When the Ghosting policy is due, the frame on screen with the window
+// merged in is shown with a full refresh instead (Update.Forced). If that
+// frame is unknown, e.g. after Setup without Clear, the window is sent
+// alone and Update.GhostingDue is set so the caller can Clear or Display.
+func (e *Epd) DisplayPartial(img image.Image, r image.Rectangle, opts ...DisplayOption) error {
+	p := e.panel()
+	o := newDisplayOptions(opts)
+	if !p.PartialRefresh {
+		return fmt.Errorf("partial refresh on %s: %w", p.Name, ErrUnsupported)
+	}
+	r, err := p.alignWindow(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", err, r)
+	}
+	if err := e.ready(STATE_MONO_READY); err != nil {
+		return err
+	}
+
+	orientAndfittedImage, err := e.fit(&img, o)
+	if err != nil {
+		return err
+	}
+	orientAndfittedImage = o.adjust(orientAndfittedImage)
+	monochromeTensor, err := o.monochrome(&orientAndfittedImage, false)
+	if err != nil {
+		return err
+	}
+	buf := p.GetEPDBuffer(monochromeTensor)
+	if e.prevMono != nil {
+		//keep the rest of the screen as it is; only r is being updated
+		merged := append([]byte(nil), e.prevMono...)
+		stride := p.Stride()
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			copy(merged[y*stride+r.Min.X/8:y*stride+r.Max.X/8], buf[y*stride+r.Min.X/8:])
 		}
-		e.Send_data(temp3)
+		buf = merged
 	}
-	e.Gray_SetLut()
-	e.Send_command(0x12)
-	time.Sleep(200 * time.Millisecond)
-	e.ReadBusy()
 
+	changed := p.changedMono(e.prevMono, buf).Intersect(r)
+	e.lastUpdate = Update{Changed: changed, Refresh: REFRESH_PARTIAL, Partial: true}
+	if !o.force {
+		if changed.Empty() {
+			e.lastUpdate.Skipped, e.lastUpdate.Partial = true, false
+			return nil
+		}
+		r, _ = p.alignWindow(changed)
+	}
+	if err := e.UpdateTemperature(); err != nil {
+		return err
+	}
+	if e.ghostingDue() {
+		if e.prevMono == nil {
+			//a full refresh would blank everything outside the window
+			e.lastUpdate.GhostingDue = true
+			return e.sendPartial(buf, r)
+		}
+		if err := e.forceFull(); err != nil {
+			return err
+		}
+		if err := e.displayMono(buf, displayOptions{force: true}); err != nil {
+			return err
+		}
+		e.lastUpdate.Forced = true
+		return nil
+	}
+	return e.sendPartial(buf, r)
 }
 
-func ConvertImageto4GrayEPDTensor(img *image.Image) (gray [][]uint8) {
-	p := imageutil.GetImageTensor(*img)
+// sendPartial writes window r of the full-frame buffer with 0x14/0x15 and
+// refreshes just that window with 0x16.
+func (e *Epd) sendPartial(buf []byte, r image.Rectangle) error {
+	p := e.panel()
+	window := p.cropBuffer(buf, r)
+	params := windowParams(r)
 
-	//convert to greyscale tensor
-	intermediateGreyImg := imageutil.ConvertGreyScale(&p)
-
-	p = imageutil.GetImageTensor(intermediateGreyImg)
-
-	for x := 0; x < len(p); x++ {
-		col := []uint8{}
-
-		for y := 0; y < len(p[0]); y++ {
-			pix := p[x][y]
+	if err := e.send(0x14, params...); err != nil { //partial old data
+		return err
+	}
+	if err := e.Send_data_bulk(bytes.Repeat([]byte{p.WhiteByte()}, len(window))); err != nil {
+		return err
+	}
+	if err := e.send(0x15, params...); err != nil { //partial new data
+		return err
+	}
+	if err := e.Send_data_bulk(window); err != nil {
+		return err
+	}
+	if err := e.send(0x16, params...); err != nil { //partial refresh
+		return err
+	}
+	if err := e.ReadBusy(); err != nil {
+		return err
+	}
 
-			originalClr, ok := color.RGBAModel.Convert(pix).(color.RGBA)
-			if !ok {
-				log.Fatalln("Color is not RGBA format.")
-			}
-			clr := originalClr.R
-			newClr := uint8(0)
-			if clr < 100 && clr > 0 { //can calibrate sigmodal curve for conversion of 0 - 255 (8bit gray) to 0 - 3 (2 bit gray)
-				newClr = 0
-			} else if clr < 140 && clr >= 100 {
-				newClr = 1
-			} else if clr < 180 && clr >= 140 {
-				newClr = 2
-			} else {
-				newClr = 3
-			}
-			newClr <<= 6 //move 2 bits at LSB to MSB
-			col = append(col, newClr)
+	if e.prevMono != nil {
+		stride, w := p.Stride(), r.Dx()/8
+		for i, y := 0, r.Min.Y; y < r.Max.Y; i, y = i+1, y+1 {
+			copy(e.prevMono[y*stride+r.Min.X/8:], window[i*w:(i+1)*w])
 		}
-		gray = append(gray, col)
 	}
-	return
+	e.noteUpdate()
+	return nil
 }
 
-func ConvertImagetoMonochromeEPDTensorWithDither(img *image.Image) (monochrome [][]uint8) {
-	p := imageutil.GetImageTensor(*img)
+func (e *Epd) Display_4Gray(img *image.Image, opts ...DisplayOption) error {
+	p := e.panel()
+	if !p.Supports(COLOR_GRAY4) {
+		return fmt.Errorf("4 gray on %s: %w", p.Name, ErrUnsupported)
+	}
+	if err := e.ready(STATE_GRAY_READY); err != nil {
+		return err
+	}
+	o := newDisplayOptions(opts)
+	orientAndfittedImage, err := e.fit(img, o)
+	if err != nil {
+		return err
+	}
 
-	//convert to greyscale tensor
-	intermediateGreyImg := imageutil.ConvertGreyScale(&p)
-	threshold := computeOstuThreshold(&intermediateGreyImg)
-	fmt.Printf("Computed threshold %d \n", threshold)
-	//obtained greyscale tensor
-	p = imageutil.GetImageTensor(intermediateGreyImg)
-	dithered := fsDitheringGreyTensorWithThreshold(p, threshold)
+	orientAndfittedImage = o.adjust(orientAndfittedImage)
+	var grayTensor [][]uint8
+	if o.ditherer != nil {
+		grayTensor, err = ConvertImageto4GrayEPDTensorWithDitherer(&orientAndfittedImage, o.ditherer)
+	} else {
+		grayTensor, err = ConvertImageto4GrayEPDTensor(&orientAndfittedImage)
+	}
+	if err != nil {
+		return err
+	}
 
-	for x := 1; x < len(p)+1; x++ {
-		col := []uint8{}
-		for y := 1; y < len(p[0])+1; y++ {
-			pix := dithered[x][y]
+	return e.displayGray4(p.GetEPDBuffer_4Gray(grayTensor), o)
+}
 
-			if pix < uint16(threshold) {
-				col = append(col, uint8(255))
-			} else {
-				col = append(col, uint8(0))
-			}
-		}
-		monochrome = append(monochrome, col)
+// DisplayGray4Frame shows f as is; its bounds, stride and Pix must be the
+// panel's portrait size, or ErrFrameSize is returned.
+func (e *Epd) DisplayGray4Frame(f *Gray4Frame, opts ...DisplayOption) error {
+	p := e.panel()
+	if !p.Supports(COLOR_GRAY4) {
+		return fmt.Errorf("4 gray on %s: %w", p.Name, ErrUnsupported)
 	}
-	return
+	if f.Rect.Dx() != p.Width || f.Rect.Dy() != p.Height || f.Stride != (p.Width+3)/4 {
+		return fmt.Errorf("%w: gray4 frame %v on %s", ErrFrameSize, f.Rect.Size(), p.Name)
+	}
+	if len(f.Pix) < p.Gray4BufferSize() {
+		return fmt.Errorf("%w: gray4 frame of %d bytes, %s takes %d", ErrFrameSize, len(f.Pix), p.Name, p.Gray4BufferSize())
+	}
+	if err := e.ready(STATE_GRAY_READY); err != nil {
+		return err
+	}
+	return e.displayGray4(f.Pix[:p.Gray4BufferSize()], newDisplayOptions(opts))
 }
 
-func ConvertImagetoMonochromeEPDTensor(img *image.Image) (monochrome [][]uint8) {
-	p := imageutil.GetImageTensor(*img)
-
-	//convert to greyscale tensor
-	intermediateGreyImg := imageutil.ConvertGreyScale(&p)
-	threshold := computeOstuThreshold(&intermediateGreyImg)
-	fmt.Printf("Computed threshold %d \n", threshold)
-	//obtained greyscale tensor
-	p = imageutil.GetImageTensor(intermediateGreyImg)
-
-	for x := 0; x < len(p); x++ {
-		col := []uint8{}
-		for y := 0; y < len(p[0]); y++ {
-			pix := p[x][y]
-			c, ok := color.RGBAModel.Convert(pix).(color.RGBA)
-			clr := c.R
-			if !ok {
-				log.Fatalf("Cannot convert to RGBAModel space \n")
-			}
+// DisplayGray4Buffer shows a frame already packed the way GetEPDBuffer_4Gray
+// packs it, skipping all image processing. Its length must be
+// Gray4BufferSize.
+func (e *Epd) DisplayGray4Buffer(buf []byte, opts ...DisplayOption) error {
+	p := e.panel()
+	if !p.Supports(COLOR_GRAY4) {
+		return fmt.Errorf("4 gray on %s: %w", p.Name, ErrUnsupported)
+	}
+	if len(buf) != p.Gray4BufferSize() {
+		return fmt.Errorf("%w: gray4 buffer of %d bytes, %s takes %d", ErrBufferSize, len(buf), p.Name, p.Gray4BufferSize())
+	}
+	if err := e.ready(STATE_GRAY_READY); err != nil {
+		return err
+	}
+	return e.displayGray4(buf, newDisplayOptions(opts))
+}
+
+// displayGray4 splits a packed 2 bit buffer into the two planes, loads the
+// 4 gray LUTs and refreshes.
+func (e *Epd) displayGray4(buf []byte, o displayOptions) error {
+	p := e.panel()
+	changed := p.changedGray4(e.prevGray4, buf)
+	e.lastUpdate = Update{Changed: changed, Skipped: !o.force && changed.Empty()}
+	if e.lastUpdate.Skipped {
+		return nil
+	}
+	if err := e.UpdateTemperature(); err != nil {
+		return err
+	}
+	oldPlane, newPlane := gray4Planes(buf)
+	if err := e.sendFrame(oldPlane, newPlane); err != nil {
+		return err
+	}
+	e.prevMono = nil
+	e.prevGray4 = append(e.prevGray4[:0], buf...)
+	if err := e.Gray_SetLut(); err != nil {
+		return err
+	}
+	if err := e.Send_command(0x12); err != nil {
+		return err
+	}
+	e.delay(200 * time.Millisecond)
+	if err := e.ReadBusy(); err != nil {
+		return err
+	}
+	e.noteFullRefresh()
+	return nil
+}
 
-			if clr < uint8(threshold) {
-				col = append(col, uint8(255))
-			} else {
-				col = append(col, uint8(0))
+// gray4Planes splits a 2 bit per pixel buffer into the 0x10 and 0x13 planes:
+// white 11 -> (1,1), gray1 10 -> (1,0), gray2 01 -> (0,1), black 00 -> (0,0).
+func gray4Planes(grayBslices []byte) (oldPlane, newPlane []byte) {
+	oldPlane = make([]byte, len(grayBslices)/2)
+	newPlane = make([]byte, len(grayBslices)/2)
+	for i := range oldPlane {
+		var o, n uint8
+		for j := 0; j < 2; j++ {
+			temp1 := grayBslices[i*2+j]
+			for k := 0; k < 4; k++ {
+				temp2 := temp1 & 0xC0 //0xC0 is 1100 0000
+				o <<= 1
+				n <<= 1
+				o |= temp2 >> 7       //first bit set for white and gray1
+				n |= (temp2 >> 6) & 1 //second bit set for white and gray2
+				temp1 <<= 2
 			}
 		}
-		monochrome = append(monochrome, col)
+		oldPlane[i] = o
+		newPlane[i] = n
 	}
 	return
 }
 
-func getHistorgramGreyscaleTensor(grey *image.Image) (hist []int, numPixels int) {
-	pg := *grey
-	size := pg.Bounds().Size()
-	his := make([]int, 256)
-	for x := 0; x < size.X; x++ {
-		for y := 0; y < size.Y; y++ {
-			clr := pg.At(x, y)
-			originalColor, ok := color.RGBAModel.Convert(clr).(color.RGBA)
-			if ok {
-				his[int(originalColor.R)]++
-			}
+func ConvertImageto4GrayEPDTensor(img *image.Image) (gray [][]uint8, err error) {
+	g := imageutil.ToGray(*img)
+	return levelTensor(thresholdImage(g, Gray4BandQuantizer), gray4TensorLevels), nil
+}
+
+// ConvertImagetoMonochromeEPDTensorWithDither dithers img around its Otsu
+// threshold with FloydSteinberg.
+func ConvertImagetoMonochromeEPDTensorWithDither(img *image.Image) (monochrome [][]uint8, err error) {
+	g := imageutil.ToGray(*img)
+	threshold := otsuThreshold(grayHistogram(g), len(g.Pix))
+	return levelTensor(FloydSteinberg.Dither(g, MonoQuantizer(threshold)), monoTensorLevels), nil
+}
 
+func ConvertImagetoMonochromeEPDTensor(img *image.Image) (monochrome [][]uint8, err error) {
+	g := imageutil.ToGray(*img)
+	threshold := otsuThreshold(grayHistogram(g), len(g.Pix))
+	//otsu threshold is the last level of the dark class
+	return levelTensor(thresholdImage(g, MonoQuantizer(threshold)), monoTensorLevels), nil
+}
+
+// grayHistogram counts the pixels at each of the 256 levels.
+func grayHistogram(g *image.Gray) []int {
+	hist := make([]int, 256)
+	for y := 0; y < g.Rect.Dy(); y++ {
+		for _, v := range g.Pix[y*g.Stride : y*g.Stride+g.Rect.Dx()] {
+			hist[v]++
 		}
 	}
-	numPixels = size.X * size.Y
-	hist = his
-	return
+	return hist
 }
 
-func computeOstuThreshold(grey *image.Image) (threshold int) {
-	hist, numPixels := getHistorgramGreyscaleTensor(grey)
-
+// otsuThreshold is the last level of the dark class that maximises the
+// variance between the two classes.
+func otsuThreshold(hist []int, numPixels int) (threshold int) {
 	sum := float64(0)
 
 	for t := 0; t < 256; t++ {
@@ -661,159 +874,14 @@ func computeOstuThreshold(grey *image.Image) (threshold int) {
 	return
 }
 
+// GetEPDBuffer packs a monochrome tensor for the 2.7" panel, see
+// Panel.GetEPDBuffer.
 func GetEPDBuffer(monochrome [][]uint8) []byte {
-	imgWidth := len(monochrome)
-	imgHeight := len(monochrome[0])
-
-	buffLength := EPD_WIDTH / 8 * EPD_HEIGHT
-	buf := make([]byte, buffLength)
-	for i := 0; i < buffLength; i++ {
-		buf[i] = 0xff
-	}
-
-	if imgWidth == EPD_WIDTH && imgHeight == EPD_HEIGHT {
-		//image is verticals
-		for y := 0; y < imgHeight; y++ {
-			for x := 0; x < imgWidth; x++ {
-				if monochrome[x][y] == 0 {
-					index := (x + y*EPD_WIDTH) / 8
-					buf[index] &= ^(0x80 >> (x % 8)) // x bit will be 0 while rest are 1s whick allow masking
-				}
-			}
-		}
-	} else if imgWidth == EPD_HEIGHT && imgHeight == EPD_WIDTH {
-		//image is horizontal
-		for y := 0; y < imgHeight; y++ {
-			for x := 0; x < imgWidth; x++ {
-				if monochrome[x][y] == 0 {
-					newx := y
-					newy := EPD_HEIGHT - newx - 1
-					index := (newx + newy*EPD_WIDTH) / 8
-					buf[index] &= ^(0x80 >> (newx % 8))
-
-				}
-			}
-		}
-	}
-
-	return buf
+	return Panel_2in7.GetEPDBuffer(monochrome)
 }
 
-func findClosetPaletteColorWithThreshold(oldPixelColor uint16, threshold int) (newGrey uint16, quant_error uint16) {
-
-	if oldPixelColor > uint16(threshold) {
-		newGrey = 255 //clipped
-	} else {
-		newGrey = 0
-	}
-	quant_error = oldPixelColor - newGrey //will always be a positive uint16 or zero
-	return
-}
-
-func fsDitheringGreyTensorWithThreshold(pixels [][]color.Color, threshold int) (greypixUint16 [][]uint16) { //use uint16 to prevent overflow of uint8
-	y_zeros := []uint16{}
-	for a := 0; a < len(pixels[0])+2; a++ {
-		y_zeros = append(y_zeros, 0)
-	}
-	greypixUint16 = append(greypixUint16, y_zeros) //add left zeros
-
-	for x := 0; x < len(pixels); x++ {
-		y_pixels := []uint16{}
-		y_pixels = append(y_pixels, 0) //add top zeros
-		for y := 0; y < len(pixels[0]); y++ {
-			clr := pixels[x][y]
-			originalClr, ok := color.RGBAModel.Convert(clr).(color.RGBA)
-			if !ok {
-				break
-			}
-			y_pixels = append(y_pixels, uint16(originalClr.R))
-		}
-		y_pixels = append(y_pixels, 0) //add bottom zeros
-		greypixUint16 = append(greypixUint16, y_pixels)
-	}
-
-	y_zeros = []uint16{}
-	for a := 0; a < len(pixels[0])+2; a++ {
-		y_zeros = append(y_zeros, 0)
-	}
-	greypixUint16 = append(greypixUint16, y_zeros) //add right zeros
-
-	for x := 1; x < len(pixels)+1; x++ {
-		for y := 1; y < len(pixels[0])+1; y++ {
-			//https://www.visgraf.impa.br/Courses/ip00/proj/Dithering1/floyd_steinberg_dithering.html
-			//https://en.wikipedia.org/wiki/Floyd%E2%80%93Steinberg_dithering
-			newClr, quant_error := findClosetPaletteColorWithThreshold(greypixUint16[x][y], threshold)
-			greypixUint16[x][y] = newClr
-			greypixUint16[x+1][y] += quant_error * uint16(7) / uint16(16)
-			if greypixUint16[x+1][y] > 255 {
-				greypixUint16[x+1][y] = 255
-
-			}
-			greypixUint16[x-1][y+1] += quant_error * uint16(3) / uint16(16)
-			if greypixUint16[x-1][y+1] > 255 {
-				greypixUint16[x-1][y+1] = 255
-
-			}
-			greypixUint16[x][y+1] += quant_error * uint16(5) / uint16(16)
-			if greypixUint16[x][y+1] > 255 {
-				greypixUint16[x][y+1] = 255
-
-			}
-			greypixUint16[x+1][y+1] += quant_error * uint16(1) / uint16(16)
-			if greypixUint16[x+1][y+1] > 255 {
-				greypixUint16[x+1][y+1] = 255
-
-			}
-		}
-	}
-
-	return // bigger width + 2 and height + 2
-}
-
-func GetEPDBuffer_4Gray(imgTensor [][]uint8) []byte { //one unint8 represents one pixel
-	imgWidth := len(imgTensor)
-	imgHeight := len(imgTensor[0])
-	buf := make([]byte, EPD_WIDTH/4*EPD_HEIGHT)
-	for b := 0; b < EPD_HEIGHT*EPD_WIDTH/4; b++ {
-		buf[b] = 0xff
-	}
-
-	var i int
-	if imgWidth == EPD_WIDTH && imgHeight == EPD_HEIGHT {
-		//portrait
-		for y := 0; y < imgHeight; y++ {
-			for x := 0; x < imgWidth; x++ {
-				// fmt.Printf("%x\n", imgTensor[x][y])
-				if imgTensor[x][y] == 0xC0 {
-					imgTensor[x][y] = 0x80
-				} else if imgTensor[x][y] == 0x80 {
-					imgTensor[x][y] = 0x40
-				}
-				i += 1
-				if i%4 == 0 { //every 4 bytes in tensor
-					buf[int((x+(y*EPD_WIDTH))/4)] = (imgTensor[x-3][y] & 0xc0) | ((imgTensor[x-2][y] & 0xc0) >> 2) | ((imgTensor[x-1][y] & 0xc0) >> 4) | ((imgTensor[x][y] & 0xc0) >> 6)
-				}
-			}
-		}
-	} else if imgWidth == EPD_HEIGHT && imgHeight == EPD_WIDTH {
-		//landscape
-		for x := 0; x < imgWidth; x++ {
-			for y := 0; y < imgHeight; y++ {
-				newx := y //buff space
-				newy := EPD_HEIGHT - x - 1
-
-				if imgTensor[x][y] == 0xC0 {
-					imgTensor[x][y] = 0x80
-				} else if imgTensor[x][y] == 0x80 {
-					imgTensor[x][y] = 0x40
-				}
-				i += 1
-				if i%4 == 0 { //every 4 bytes in tensor
-					buf[int((newx+(newy*EPD_WIDTH))/4)] = (imgTensor[x][y-3] & 0xc0) | ((imgTensor[x][y-2] & 0xc0) >> 2) | ((imgTensor[x][y-1] & 0xc0) >> 4) | ((imgTensor[x][y] & 0xc0) >> 6)
-				}
-
-			}
-		}
-	}
-	return buf
+// GetEPDBuffer_4Gray packs a 4 gray tensor for the 2.7" panel, see
+// Panel.GetEPDBuffer_4Gray.
+func GetEPDBuffer_4Gray(imgTensor [][]uint8) []byte {
+	return Panel_2in7.GetEPDBuffer_4Gray(imgTensor)
 }
@@ -1,8 +1,10 @@
 package epd_config
 
 import (
-	"log"
+	"errors"
+	"fmt"
 
+	"periph.io/x/conn/v3"
 	"periph.io/x/conn/v3/gpio"
 	"periph.io/x/conn/v3/gpio/gpioreg"
 	"periph.io/x/conn/v3/physic"
@@ -18,73 +20,226 @@ const ( //GPIO pins i.e. GPIO17, GPIO25...
 	BUSY_PIN = "24"
 )
 
+const DEFAULT_MAX_FREQUENCY = 4 * physic.MegaHertz
 
-type EpdConfig struct{
+var (
+	ErrHostInit    = errors.New("cannot initialise host drivers")
+	ErrSPIOpen     = errors.New("cannot open spi port")
+	ErrSPIConnect  = errors.New("cannot connect to spi port")
+	ErrPin         = errors.New("gpio pin failure")
+	ErrPinNotFound = errors.New("gpio pin not found")
+	ErrTransfer    = errors.New("spi transfer failed")
+	ErrNotSetup    = errors.New("transport is not set up")
+)
+
+// Options selects the wiring of the HAT. Empty fields fall back to the
+// Waveshare defaults: GPIO17/25/8/24, first SPI bus, mode 0 at 4 MHz.
+type Options struct {
+	RstPin       string //gpioreg name, e.g. "GPIO17"
+	DcPin        string
+	CsPin        string
+	BusyPin      string
+	SPIPort      string //spireg name, e.g. "SPI1.0"; empty for the first bus
+	SPIMode      spi.Mode
+	MaxFrequency physic.Frequency
+}
+
+type Option func(*Options)
+
+func DefaultOptions() Options {
+	return Options{
+		RstPin:       "GPIO" + RST_PIN,
+		DcPin:        "GPIO" + DC_PIN,
+		CsPin:        "GPIO" + CS_PIN,
+		BusyPin:      "GPIO" + BUSY_PIN,
+		SPIPort:      "",
+		SPIMode:      spi.Mode0,
+		MaxFrequency: DEFAULT_MAX_FREQUENCY,
+	}
+}
+
+func WithPins(rst, dc, cs, busy string) Option {
+	return func(o *Options) {
+		o.RstPin, o.DcPin, o.CsPin, o.BusyPin = rst, dc, cs, busy
+	}
+}
+
+func WithSPIPort(name string) Option {
+	return func(o *Options) {
+		o.SPIPort = name
+	}
+}
+
+func WithSPIMode(mode spi.Mode) Option {
+	return func(o *Options) {
+		o.SPIMode = mode
+	}
+}
+
+func WithMaxFrequency(f physic.Frequency) Option {
+	return func(o *Options) {
+		o.MaxFrequency = f
+	}
+}
+
+type EpdConfig struct {
+	Options Options
 
 	Port spi.PortCloser
 	Conn spi.Conn
-	Rst gpio.PinIO
-	Dc gpio.PinIO
-	Cs gpio.PinIO
-	Bs gpio.PinIO
+	Rst  gpio.PinIO
+	Dc   gpio.PinIO
+	Cs   gpio.PinIO
+	Bs   gpio.PinIO
+}
+
+var _ Transport = (*EpdConfig)(nil)
+
+// NewEpdConfig returns a config with the default wiring changed by opts.
+// A zero EpdConfig{} is also valid and uses the defaults.
+func NewEpdConfig(opts ...Option) *EpdConfig {
+	o := DefaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &EpdConfig{Options: o}
 }
 
+// options returns d.Options with empty fields filled from DefaultOptions.
+func (d *EpdConfig) options() Options {
+	o, def := d.Options, DefaultOptions()
+	if o.RstPin == "" {
+		o.RstPin = def.RstPin
+	}
+	if o.DcPin == "" {
+		o.DcPin = def.DcPin
+	}
+	if o.CsPin == "" {
+		o.CsPin = def.CsPin
+	}
+	if o.BusyPin == "" {
+		o.BusyPin = def.BusyPin
+	}
+	if o.MaxFrequency == 0 {
+		o.MaxFrequency = def.MaxFrequency
+	}
+	return o
+}
 
 func (d *EpdConfig) Setup() error {
-	_, err := host.Init()
+	o := d.options()
+
+	// Setup again, e.g. after Sleep, must not leak the port it opened before.
+	if err := d.Destroy(); err != nil {
+		return err
+	}
 
+	_, err := host.Init()
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("%w: %v", ErrHostInit, err)
+	}
+
+	// Pins are resolved first so bad names fail before the bus is opened.
+	if err = d.setupPins(o); err != nil {
+		return err
 	}
 
-	// Use spireg SPI port registry to find the first available SPI bus.
-	d.Port, err = spireg.Open("")
+	// An empty name asks the spireg registry for the first available bus.
+	d.Port, err = spireg.Open(o.SPIPort)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("%w %q: %v", ErrSPIOpen, o.SPIPort, err)
 	}
 
-	d.Conn, err = d.Port.Connect(4*physic.MegaHertz, spi.Mode0, 8)
+	d.Conn, err = d.Port.Connect(o.MaxFrequency, o.SPIMode, 8)
 	if err != nil {
-		log.Fatal(err)
+		d.Port.Close()
+		return fmt.Errorf("%w: %v", ErrSPIConnect, err)
 	}
+	return nil
+}
 
-	d.setupPins()
+func lookupPin(role, name string) (gpio.PinIO, error) {
+	p := gpioreg.ByName(name)
+	if p == nil {
+		return nil, fmt.Errorf("%w: %s pin %q", ErrPinNotFound, role, name)
+	}
+	return p, nil
+}
 
+func (d *EpdConfig) setupPins(o Options) (err error) {
+	if d.Rst, err = lookupPin("RST", o.RstPin); err != nil {
+		return
+	}
+	if d.Dc, err = lookupPin("DC", o.DcPin); err != nil {
+		return
+	}
+	if d.Cs, err = lookupPin("CS", o.CsPin); err != nil {
+		return
+	}
+	if d.Bs, err = lookupPin("BUSY", o.BusyPin); err != nil {
+		return
+	}
+	if err = d.Bs.In(gpio.PullUp, gpio.NoEdge); err != nil {
+		return fmt.Errorf("%w: busy pin: %v", ErrPin, err)
+	}
 	return nil
-	
 }
 
-func (d* EpdConfig) setupPins(){
-	d.Rst = gpioreg.ByName("GPIO" + RST_PIN)
-	d.Dc = gpioreg.ByName("GPIO" + DC_PIN)
-	d.Bs = gpioreg.ByName("GPIO" + BUSY_PIN)
-	d.Bs.In(gpio.PullUp, gpio.NoEdge)
-	d.Cs = gpioreg.ByName("GPIO" + CS_PIN)
-
+func writePin(p gpio.PinIO, l gpio.Level) error {
+	if p == nil {
+		return ErrNotSetup
+	}
+	if err := p.Out(l); err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrPin, p.Name(), err)
+	}
+	return nil
 }
 
-func (d* EpdConfig) Digital_writeRST(l gpio.Level){
-	d.Rst.Out(l)
+func (d *EpdConfig) Digital_writeRST(l gpio.Level) error {
+	return writePin(d.Rst, l)
 }
 
-func (d* EpdConfig) Digital_writeDC(l gpio.Level){
-	d.Dc.Out(l)
+func (d *EpdConfig) Digital_writeDC(l gpio.Level) error {
+	return writePin(d.Dc, l)
 }
 
-func (d* EpdConfig) Digital_writeCS(l gpio.Level){
-	d.Cs.Out(l)
+func (d *EpdConfig) Digital_writeCS(l gpio.Level) error {
+	return writePin(d.Cs, l)
 }
 
-func (d* EpdConfig) Digital_readBS() gpio.Level{
+func (d *EpdConfig) Digital_readBS() gpio.Level {
+	if d.Bs == nil {
+		return gpio.High //not set up; report idle rather than spin forever
+	}
 	return d.Bs.Read()
 }
 
-func (d *EpdConfig) Destroy() {
-	d.Port.Close()
+func (d *EpdConfig) Destroy() error {
+	if d.Port == nil {
+		return nil
+	}
+	err := d.Port.Close()
+	d.Port, d.Conn = nil, nil
+	return err
 }
 
-func (d *EpdConfig) WriteBytes(data []byte){
-	if err := d.Conn.Tx(data, nil); err != nil{
-		log.Println(err)
+func (d *EpdConfig) WriteBytes(data []byte) error {
+	if d.Conn == nil {
+		return ErrNotSetup
+	}
+	if err := d.Conn.Tx(data, nil); err != nil {
+		return fmt.Errorf("%w: %v", ErrTransfer, err)
 	}
-}
\ No newline at end of file
+	return nil
+}
+
+// MaxTxSize reports the largest transfer the SPI driver accepts, as told by
+// the connection, or DEFAULT_MAX_TX_SIZE when it does not say.
+func (d *EpdConfig) MaxTxSize() int {
+	if l, ok := d.Conn.(conn.Limits); ok {
+		if n := l.MaxTxSize(); n > 0 {
+			return n
+		}
+	}
+	return DEFAULT_MAX_TX_SIZE
+}
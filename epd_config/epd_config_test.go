@@ -0,0 +1,55 @@
+package epd_config
+
+import (
+	"errors"
+	"testing"
+
+	"periph.io/x/conn/v3/physic"
+	"periph.io/x/conn/v3/spi"
+)
+
+func TestZeroConfigUsesDefaults(t *testing.T) {
+	d := EpdConfig{}
+	if got := d.options(); got != DefaultOptions() {
+		t.Fatalf("options %+v, want defaults %+v", got, DefaultOptions())
+	}
+}
+
+func TestNewEpdConfigOptions(t *testing.T) {
+	d := NewEpdConfig(
+		WithPins("GPIO5", "GPIO6", "GPIO7", "GPIO12"),
+		WithSPIPort("SPI1.0"),
+		WithSPIMode(spi.Mode3),
+		WithMaxFrequency(2*physic.MegaHertz),
+	)
+	want := Options{
+		RstPin:       "GPIO5",
+		DcPin:        "GPIO6",
+		CsPin:        "GPIO7",
+		BusyPin:      "GPIO12",
+		SPIPort:      "SPI1.0",
+		SPIMode:      spi.Mode3,
+		MaxFrequency: 2 * physic.MegaHertz,
+	}
+	if got := d.options(); got != want {
+		t.Fatalf("options %+v, want %+v", got, want)
+	}
+}
+
+func TestBadPinNameFails(t *testing.T) {
+	d := NewEpdConfig(WithPins("NOPE", "GPIO25", "GPIO8", "GPIO24"))
+	err := d.setupPins(d.options())
+	if !errors.Is(err, ErrPinNotFound) {
+		t.Fatalf("error %v, want ErrPinNotFound", err)
+	}
+}
+
+func TestWriteBeforeSetup(t *testing.T) {
+	d := EpdConfig{}
+	if err := d.WriteBytes([]byte{0x12}); !errors.Is(err, ErrNotSetup) {
+		t.Fatalf("error %v, want ErrNotSetup", err)
+	}
+	if err := d.Digital_writeDC(true); !errors.Is(err, ErrNotSetup) {
+		t.Fatalf("error %v, want ErrNotSetup", err)
+	}
+}
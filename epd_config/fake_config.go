@@ -0,0 +1,111 @@
+package epd_config
+
+import "periph.io/x/conn/v3/gpio"
+
+// FakeWrite is one WriteBytes call seen by FakeConfig, together with the
+// level of the DC pin at that time (Low for command, High for data).
+type FakeWrite struct {
+	Dc   gpio.Level
+	Data []byte
+}
+
+// FakeConfig is an in-memory Transport. It records pin levels and every
+// byte written, and reports the panel as idle unless BusyReads is set.
+type FakeConfig struct {
+	Writes     []FakeWrite
+	Rst        gpio.Level
+	Dc         gpio.Level
+	Cs         gpio.Level
+	BusyReads  int  //number of Digital_readBS calls that report busy before going idle
+	BusyHigh   bool //BUSY is high while busy, as on IL3820 panels
+	SetupCalls int
+	Destroyed  bool
+	Err        error //when set, returned by every write
+}
+
+func (d *FakeConfig) Setup() error {
+	d.SetupCalls++
+	d.Destroyed = false
+	return d.Err
+}
+
+func (d *FakeConfig) Digital_writeRST(l gpio.Level) error {
+	d.Rst = l
+	return d.Err
+}
+
+func (d *FakeConfig) Digital_writeDC(l gpio.Level) error {
+	d.Dc = l
+	return d.Err
+}
+
+func (d *FakeConfig) Digital_writeCS(l gpio.Level) error {
+	d.Cs = l
+	return d.Err
+}
+
+func (d *FakeConfig) Digital_readBS() gpio.Level {
+	busy := d.BusyReads > 0
+	if busy {
+		d.BusyReads--
+	}
+	if d.BusyHigh {
+		return gpio.Level(busy)
+	}
+	return gpio.Level(!busy) //low is busy
+}
+
+func (d *FakeConfig) Destroy() error {
+	d.Destroyed = true
+	return nil
+}
+
+func (d *FakeConfig) WriteBytes(data []byte) error {
+	if d.Err != nil {
+		return d.Err
+	}
+	b := make([]byte, len(data))
+	copy(b, data)
+	d.Writes = append(d.Writes, FakeWrite{Dc: d.Dc, Data: b})
+	return nil
+}
+
+// Commands returns the command bytes written so far, in order.
+func (d *FakeConfig) Commands() (cmds []byte) {
+	for _, w := range d.Writes {
+		if w.Dc == gpio.Low {
+			cmds = append(cmds, w.Data...)
+		}
+	}
+	return
+}
+
+// DataAfter returns the data bytes written after the n-th occurrence
+// (counting from 0) of command cmd, up to the next command.
+func (d *FakeConfig) DataAfter(cmd byte, n int) (data []byte) {
+	seen := -1
+	inside := false
+	for _, w := range d.Writes {
+		if w.Dc == gpio.Low {
+			if inside {
+				return
+			}
+			for _, c := range w.Data {
+				if c == cmd {
+					seen++
+				}
+			}
+			inside = seen == n && w.Data[len(w.Data)-1] == cmd
+			continue
+		}
+		if inside {
+			data = append(data, w.Data...)
+		}
+	}
+	return
+}
+
+// ClearWrites forgets everything recorded so far.
+func (d *FakeConfig) ClearWrites() {
+	d.Writes = nil
+}
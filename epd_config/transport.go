@@ -0,0 +1,25 @@
+package epd_config
+
+import "periph.io/x/conn/v3/gpio"
+
+// Transport is the low-level link between the Epd driver and the panel.
+// EpdConfig talks to the real HAT through periph.io; FakeConfig keeps
+// everything in memory so the driver can run without SPI or GPIO.
+type Transport interface {
+	Setup() error
+	Digital_writeRST(l gpio.Level) error
+	Digital_writeDC(l gpio.Level) error
+	Digital_writeCS(l gpio.Level) error
+	Digital_readBS() gpio.Level
+	WriteBytes(data []byte) error
+	Destroy() error
+}
+
+const DEFAULT_MAX_TX_SIZE = 4096 //spidev bufsiz default
+
+// TxLimiter is implemented by transports that cap the number of bytes in a
+// single WriteBytes call. Transports without it are assumed to accept
+// DEFAULT_MAX_TX_SIZE.
+type TxLimiter interface {
+	MaxTxSize() int
+}
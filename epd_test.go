@@ -0,0 +1,736 @@
+package epd
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+	"time"
+
+	"github.com/mipsmonsta/epd/emulator"
+	"github.com/mipsmonsta/epd/epd_config"
+	"github.com/mipsmonsta/epd/imageutil"
+)
+
+func newFakeEpd() (*Epd, *epd_config.FakeConfig) {
+	fake := &epd_config.FakeConfig{}
+	e := &Epd{Config: fake}
+	e.sleep = func(time.Duration) {}
+	return e, fake
+}
+
+func newUniformImage(width, height int, c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+	return img
+}
+
+func TestSetupSendsInitSequence(t *testing.T) {
+	e, fake := newFakeEpd()
+	e.Setup()
+
+	if fake.SetupCalls != 1 {
+		t.Fatalf("transport setup called %d times, want 1", fake.SetupCalls)
+	}
+	cmds := fake.Commands()
+	if cmds[0] != 0x01 {
+		t.Fatalf("first command %#x, want POWER_SETTING 0x01", cmds[0])
+	}
+	if !bytes.Contains(cmds, []byte{0x20, 0x21, 0x22, 0x23, 0x24}) {
+		t.Fatalf("LUT registers not uploaded, commands %x", cmds)
+	}
+	if got := fake.DataAfter(0x20, 0); !bytes.Equal(got, lut_vcom_dc) {
+		t.Fatalf("vcom LUT mismatch, got %d bytes", len(got))
+	}
+}
+
+func TestClearWritesWhitePlanes(t *testing.T) {
+	e, fake := newFakeEpd()
+	e.Setup()
+	fake.ClearWrites()
+	e.Clear()
+
+	for _, cmd := range []byte{0x10, 0x13} {
+		data := fake.DataAfter(cmd, 0)
+		if len(data) != EPD_WIDTH*EPD_HEIGHT/8 {
+			t.Fatalf("plane %#x has %d bytes", cmd, len(data))
+		}
+		for _, b := range data {
+			if b != 0xFF {
+				t.Fatalf("plane %#x not white", cmd)
+			}
+		}
+	}
+	if cmds := fake.Commands(); cmds[len(cmds)-1] != 0x12 {
+		t.Fatalf("clear did not end with refresh, commands %x", cmds)
+	}
+}
+
+func TestReadBusyWaitsForIdle(t *testing.T) {
+	e, fake := newFakeEpd()
+	fake.BusyReads = 3
+	if err := e.ReadBusy(); err != nil || fake.BusyReads != 0 {
+		t.Fatalf("ReadBusy returned %v while panel still busy", err)
+	}
+}
+
+func TestReadBusyTimesOut(t *testing.T) {
+	e, fake := newFakeEpd()
+	var waited time.Duration
+	e.sleep = func(d time.Duration) { waited += d }
+	e.BusyTimeout = 2 * time.Second
+	fake.BusyReads = 1 << 30 //stuck busy
+	if err := e.ReadBusy(); !errors.Is(err, ErrBusyTimeout) {
+		t.Fatalf("ReadBusy error %v, want ErrBusyTimeout", err)
+	}
+	if waited != e.BusyTimeout {
+		t.Fatalf("waited %v, want %v", waited, e.BusyTimeout)
+	}
+}
+
+func TestBusyTimeoutPropagates(t *testing.T) {
+	stuck := func(t *testing.T, name string, err error) {
+		if !errors.Is(err, ErrBusyTimeout) {
+			t.Errorf("%s error %v, want ErrBusyTimeout", name, err)
+		}
+	}
+	img := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black)
+
+	e, fake := newFakeEpd()
+	fake.BusyReads = 1 << 30
+	stuck(t, "Setup", e.Setup())
+	if e.State() != STATE_UNINITIALISED {
+		t.Errorf("state %v after a stuck Setup", e.State())
+	}
+
+	e, fake = newFakeEpd()
+	e.Setup()
+	fake.BusyReads = 1 << 30
+	stuck(t, "Display", e.Display(&img, MODE_MONO_DITHER_OFF))
+	stuck(t, "DisplayPartial", e.DisplayPartial(img, image.Rect(0, 0, 64, 32), WithForce()))
+
+	e, fake = newFakeEpd()
+	e.Setup_4Gray()
+	fake.BusyReads = 1 << 30
+	stuck(t, "Display_4Gray", e.Display_4Gray(&img))
+}
+
+func TestDisplaySendsBothPlanes(t *testing.T) {
+	e, fake := newFakeEpd()
+	e.Setup()
+	fake.ClearWrites()
+	img := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black)
+	e.Display(&img, MODE_MONO_DITHER_OFF)
+
+	if len(fake.DataAfter(0x10, 0)) != EPD_WIDTH*EPD_HEIGHT/8 {
+		t.Fatalf("old plane length wrong")
+	}
+	if len(fake.DataAfter(0x13, 0)) != EPD_WIDTH*EPD_HEIGHT/8 {
+		t.Fatalf("new plane length wrong")
+	}
+}
+
+func TestDisplay4GraySendsLutAndPlanes(t *testing.T) {
+	e, fake := newFakeEpd()
+	e.Setup_4Gray()
+	fake.ClearWrites()
+	img := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Gray{Y: 120})
+	e.Display_4Gray(&img)
+
+	if len(fake.DataAfter(0x13, 0)) != EPD_WIDTH*EPD_HEIGHT/8 {
+		t.Fatalf("new plane length wrong")
+	}
+	if got := fake.DataAfter(0x25, 0); !bytes.Equal(got, gray_lut_ww) {
+		t.Fatalf("gray LUT 0x25 not uploaded")
+	}
+}
+
+func TestSleepKeepsTransportOpen(t *testing.T) {
+	e, fake := newFakeEpd()
+	e.Setup()
+	e.Sleep()
+	if fake.Destroyed {
+		t.Fatalf("transport destroyed by sleep")
+	}
+	if got := fake.DataAfter(0x07, 0); !bytes.Equal(got, []byte{0xA5}) {
+		t.Fatalf("deep sleep check code %x", got)
+	}
+}
+
+func TestCloseSleepsAndDestroysTransport(t *testing.T) {
+	e, fake := newFakeEpd()
+	e.Setup()
+	e.Close()
+	if !fake.Destroyed {
+		t.Fatalf("transport not destroyed after close")
+	}
+	if got := fake.DataAfter(0x07, 0); !bytes.Equal(got, []byte{0xA5}) {
+		t.Fatalf("deep sleep check code %x", got)
+	}
+}
+
+func newEmulatedEpd() (*Epd, *emulator.Emulator) {
+	em := emulator.New(EPD_WIDTH, EPD_HEIGHT)
+	e := &Epd{Config: em}
+	e.sleep = func(time.Duration) {}
+	return e, em
+}
+
+func TestEmulatedMonoPolarity(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+
+	//top half black, bottom half white
+	src := image.NewRGBA(image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT/2), image.Black, image.Point{}, draw.Src)
+	img := image.Image(src)
+
+	e.Display(&img, MODE_MONO_DITHER_OFF)
+	out := em.Image(emulator.RENDER_MONO)
+	if got := out.At(EPD_WIDTH/2, 10); got != color.Black {
+		t.Fatalf("top pixel %v, want black", got)
+	}
+	if got := out.At(EPD_WIDTH/2, EPD_HEIGHT-10); got != color.White {
+		t.Fatalf("bottom pixel %v, want white", got)
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
+
+func TestEmulatedLandscapeIsRotatedAntiClockwise(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+
+	//landscape image with its left half black
+	src := image.NewRGBA(image.Rect(0, 0, EPD_HEIGHT, EPD_WIDTH))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, EPD_HEIGHT/2, EPD_WIDTH), image.Black, image.Point{}, draw.Src)
+	img := image.Image(src)
+
+	e.Display(&img, MODE_MONO_DITHER_OFF)
+	out := em.Image(emulator.RENDER_MONO)
+	if got := out.At(EPD_WIDTH/2, EPD_HEIGHT-10); got != color.Black {
+		t.Fatalf("left edge of landscape should end at the bottom, got %v", got)
+	}
+	if got := out.At(EPD_WIDTH/2, 10); got != color.White {
+		t.Fatalf("right edge of landscape should end at the top, got %v", got)
+	}
+}
+
+func TestGetEPDBufferLandscape(t *testing.T) {
+	mono := make([][]uint8, EPD_HEIGHT)
+	for x := range mono {
+		mono[x] = make([]uint8, EPD_WIDTH)
+		for y := range mono[x] {
+			mono[x][y] = 255
+		}
+	}
+	mono[0][0] = 0 //top left of landscape lands bottom left of portrait
+
+	buf := GetEPDBuffer(mono)
+	index := (EPD_HEIGHT - 1) * EPD_WIDTH / 8
+	if buf[index] != 0x7F {
+		t.Fatalf("byte %d = %#x, want 0x7f", index, buf[index])
+	}
+}
+
+func TestEmulatedGray4Levels(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup_4Gray()
+
+	//four vertical bands from black to white
+	src := image.NewGray(image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT))
+	shades := []uint8{20, 120, 160, 250}
+	for y := 0; y < EPD_HEIGHT; y++ {
+		for x := 0; x < EPD_WIDTH; x++ {
+			src.SetGray(x, y, color.Gray{Y: shades[x*4/EPD_WIDTH]})
+		}
+	}
+	img := image.Image(src)
+
+	e.Display_4Gray(&img)
+	out := em.Image(emulator.RENDER_GRAY4)
+	for band := range shades {
+		x := band*EPD_WIDTH/4 + EPD_WIDTH/8
+		want := emulator.Gray4Palette[band]
+		if got := out.At(x, EPD_HEIGHT/2); got != want {
+			t.Fatalf("band %d = %v, want %v", band, got, want)
+		}
+	}
+	if !bytes.Equal(em.Lut(0x25), gray_lut_ww) {
+		t.Fatalf("gray LUT not uploaded")
+	}
+}
+
+func TestErrorsArePropagated(t *testing.T) {
+	e, fake := newFakeEpd()
+	fake.Err = epd_config.ErrTransfer
+
+	if err := e.Setup(); !errors.Is(err, epd_config.ErrTransfer) {
+		t.Fatalf("Setup error %v, want ErrTransfer", err)
+	}
+	fake.Err = nil
+	e.Setup_4Gray()
+	fake.Err = epd_config.ErrTransfer
+	if err := e.Clear(); !errors.Is(err, epd_config.ErrTransfer) {
+		t.Fatalf("Clear error %v, want ErrTransfer", err)
+	}
+	if err := e.Send_command(0x12); !errors.Is(err, epd_config.ErrTransfer) {
+		t.Fatalf("Send_command error %v, want ErrTransfer", err)
+	}
+
+	img := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.White)
+	if err := e.Display_4Gray(&img); !errors.Is(err, epd_config.ErrTransfer) {
+		t.Fatalf("Display_4Gray error %v, want ErrTransfer", err)
+	}
+	if err := e.Sleep(); !errors.Is(err, epd_config.ErrTransfer) {
+		t.Fatalf("Sleep error %v, want ErrTransfer", err)
+	}
+}
+
+func TestDisplayUnknownMode(t *testing.T) {
+	e, _ := newFakeEpd()
+	img := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.White)
+	if err := e.Display(&img, Mode(42)); !errors.Is(err, ErrUnknownMode) {
+		t.Fatalf("error %v, want ErrUnknownMode", err)
+	}
+}
+
+func TestClosedEmulatorReturnsError(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+	em.Destroy()
+	if err := e.Clear(); !errors.Is(err, epd_config.ErrTransfer) {
+		t.Fatalf("error %v, want ErrTransfer", err)
+	}
+}
+
+func TestSendDataBulkIsChunked(t *testing.T) {
+	e, em := newEmulatedEpd()
+	em.MaxTx = 1000
+	e.Setup()
+	before := em.Transactions()
+
+	if err := e.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	//two planes of 5808 bytes in 6 chunks each, plus 3 commands
+	if got := em.Transactions() - before; got != 2*6+3 {
+		t.Fatalf("clear took %d transactions, want 15", got)
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+	if got := em.Image(emulator.RENDER_MONO).At(0, 0); got != color.White {
+		t.Fatalf("clear left pixel %v", got)
+	}
+}
+
+func TestSendDataBulkMatchesSendData(t *testing.T) {
+	bulk, bulkEm := newEmulatedEpd()
+	single, singleEm := newEmulatedEpd()
+	bulk.Setup()
+	single.Setup()
+
+	buf := bytes.Repeat([]byte{0xAA}, EPD_WIDTH*EPD_HEIGHT/8)
+	bulk.Send_command(0x13)
+	bulk.Send_data_bulk(buf)
+	single.Send_command(0x13)
+	for _, b := range buf {
+		single.Send_data(b)
+	}
+	if !bytes.Equal(bulkEm.Plane(0x13), singleEm.Plane(0x13)) {
+		t.Fatalf("bulk and per byte planes differ")
+	}
+}
+
+func benchmarkClear(b *testing.B, perByte bool) {
+	e, em := newEmulatedEpd()
+	em.TxLatency = 0 //set to e.g. 20 * time.Microsecond to model spidev overhead
+	e.Setup()
+	start := em.Transactions()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !perByte {
+			e.Clear()
+			continue
+		}
+		for _, cmd := range []byte{0x10, 0x13} {
+			e.Send_command(cmd)
+			for j := 0; j < EPD_WIDTH*EPD_HEIGHT/8; j++ {
+				e.Send_data(0xFF)
+			}
+		}
+		e.Send_command(0x12)
+	}
+	b.ReportMetric(float64(em.Transactions()-start)/float64(b.N), "tx/op")
+}
+
+func BenchmarkClearPerByte(b *testing.B) {
+	benchmarkClear(b, true)
+}
+
+func BenchmarkClearBulk(b *testing.B) {
+	benchmarkClear(b, false)
+}
+
+func TestDisplayPartialOnlyTouchesWindow(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+	e.Clear()
+
+	src := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black)
+	r := image.Rect(20, 30, 61, 50)
+	if err := e.DisplayPartial(src, r); err != nil {
+		t.Fatal(err)
+	}
+
+	out := em.Image(emulator.RENDER_MONO)
+	aligned := image.Rect(16, 30, 64, 50)
+	for y := 0; y < EPD_HEIGHT; y++ {
+		for x := 0; x < EPD_WIDTH; x++ {
+			want := color.Color(color.White)
+			if image.Pt(x, y).In(aligned) {
+				want = color.Black
+			}
+			if got := out.At(x, y); got != want {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+	if em.PartialRefreshes() != 1 || em.Refreshes() != 1 {
+		t.Fatalf("refreshes full %d partial %d, want 1 and 1", em.Refreshes(), em.PartialRefreshes())
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
+
+func TestDisplayPartialRejectsEmptyWindow(t *testing.T) {
+	e, _ := newEmulatedEpd()
+	src := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black)
+	if err := e.DisplayPartial(src, image.Rect(300, 300, 310, 310)); !errors.Is(err, ErrInvalidWindow) {
+		t.Fatalf("error %v, want ErrInvalidWindow", err)
+	}
+}
+
+func TestFastRefreshUsesPreviousFrameAndSwapsLut(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+
+	black := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black)
+	white := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.White)
+	e.Display(&black, MODE_MONO_DITHER_OFF)
+	if err := e.Display(&white, MODE_MONO_DITHER_OFF, WithRefresh(REFRESH_FAST)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(em.Lut(0x22), lut_fast_bw) {
+		t.Fatalf("fast LUT not loaded: % x", em.Lut(0x22)[:6])
+	}
+	if old := em.Plane(0x10); old[0] != 0x00 {
+		t.Fatalf("old plane %#02x, want the previous black frame", old[0])
+	}
+	if got := em.Image(emulator.RENDER_MONO).At(0, 0); got != color.White {
+		t.Fatalf("pixel %v after fast refresh, want white", got)
+	}
+
+	e.Display(&black, MODE_MONO_DITHER_OFF)
+	if !bytes.Equal(em.Lut(0x22), lut_bw) {
+		t.Fatal("full LUT not restored after a fast refresh")
+	}
+	if old := em.Plane(0x10); old[0] != 0xff {
+		t.Fatalf("old plane %#02x on full refresh, want white", old[0])
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
+
+func TestFastRefreshUnsupported(t *testing.T) {
+	fake := &epd_config.FakeConfig{}
+	e, _ := NewEpd(fake, MODEL_4IN2)
+	e.sleep = func(time.Duration) {}
+	e.Setup()
+	img := newUniformImage(400, 300, color.White)
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF, WithRefresh(REFRESH_FAST)); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("got %v, want ErrUnsupported", err)
+	}
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF, WithRefresh(7)); !errors.Is(err, ErrUnknownRefresh) {
+		t.Fatalf("got %v, want ErrUnknownRefresh", err)
+	}
+}
+
+func TestEmulatedRotationSetting(t *testing.T) {
+	//landscape image with its left half black
+	src := image.NewRGBA(image.Rect(0, 0, EPD_HEIGHT, EPD_WIDTH))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, EPD_HEIGHT/2, EPD_WIDTH), image.Black, image.Point{}, draw.Src)
+	img := image.Image(src)
+
+	//where the black half ends up on the portrait panel
+	black := map[Rotation]image.Point{
+		ROTATE_AUTO: {EPD_WIDTH / 2, EPD_HEIGHT - 10},
+		ROTATE_90:   {EPD_WIDTH / 2, 10},
+		ROTATE_270:  {EPD_WIDTH / 2, EPD_HEIGHT - 10},
+		ROTATE_0:    {10, EPD_HEIGHT / 2}, //not turned, left stays left
+		ROTATE_180:  {EPD_WIDTH - 10, EPD_HEIGHT / 2},
+	}
+	for r, at := range black {
+		e, em := newEmulatedEpd()
+		e.Rotation = r
+		e.Setup()
+		if err := e.Display(&img, MODE_MONO_DITHER_OFF); err != nil {
+			t.Fatal(err)
+		}
+		out := em.Image(emulator.RENDER_MONO)
+		if got := out.At(at.X, at.Y); got != color.Black {
+			t.Errorf("rotation %d: %v is %v, want black", r, at, got)
+		}
+	}
+
+	e, _ := newEmulatedEpd()
+	e.Rotation = 7
+	e.Setup()
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF); !errors.Is(err, ErrUnknownRotation) {
+		t.Fatalf("rotation 7: %v", err)
+	}
+}
+
+// newEmulated4in2 is newEmulatedEpd for the landscape 4.2" panel.
+func newEmulated4in2(t *testing.T) (*Epd, *emulator.Emulator) {
+	em := emulator.New(Panel_4in2.Width, Panel_4in2.Height)
+	e, err := NewEpd(em, MODEL_4IN2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.sleep = func(time.Duration) {}
+	e.Setup()
+	return e, em
+}
+
+func TestEmulatedAutoRotationFollowsPanel(t *testing.T) {
+	e, em := newEmulated4in2(t)
+
+	//landscape like the panel, left half black: not turned
+	src := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, 200, 300), image.Black, image.Point{}, draw.Src)
+	img := image.Image(src)
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF); err != nil {
+		t.Fatal(err)
+	}
+	out := em.Image(emulator.RENDER_MONO)
+	if got := out.At(10, 150); got != color.Black {
+		t.Errorf("left %v, want black", got)
+	}
+	if got := out.At(390, 150); got != color.White {
+		t.Errorf("right %v, want white", got)
+	}
+	if got := out.At(10, 10); got != color.Black {
+		t.Errorf("top left %v, want black", got)
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
+
+func TestEmulated4in2PortraitImage(t *testing.T) {
+	e, em := newEmulated4in2(t)
+
+	//portrait, top half black: turned anticlockwise to fill the panel
+	src := image.NewRGBA(image.Rect(0, 0, 300, 400))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, 300, 200), image.Black, image.Point{}, draw.Src)
+	img := image.Image(src)
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF); err != nil {
+		t.Fatal(err)
+	}
+	out := em.Image(emulator.RENDER_MONO)
+	if out.Bounds().Size() != image.Pt(400, 300) {
+		t.Fatalf("rendered %v", out.Bounds())
+	}
+	for _, y := range []int{10, 150, 290} {
+		if got := out.At(10, y); got != color.Black {
+			t.Errorf("left at y %d is %v, want black", y, got)
+		}
+		if got := out.At(390, y); got != color.White {
+			t.Errorf("right at y %d is %v, want white", y, got)
+		}
+	}
+}
+
+func TestEmulatedDisplayWithFit(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+
+	//small black square, a logo
+	src := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	draw.Draw(src, src.Bounds(), image.Black, image.Point{}, draw.Src)
+	img := image.Image(src)
+
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF, WithFit(imageutil.FitOptions{Mode: imageutil.ScaleCenter})); err != nil {
+		t.Fatal(err)
+	}
+	out := em.Image(emulator.RENDER_MONO)
+	if got := out.At(EPD_WIDTH/2, EPD_HEIGHT/2); got != color.Black {
+		t.Fatalf("center %v, want black", got)
+	}
+	if got := out.At(10, 10); got != color.White {
+		t.Fatalf("padding %v, want white", got)
+	}
+}
+
+func TestEmulatedDefaultFitContains(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+
+	//black, three times as wide as high: turned, then padded left and right
+	img := newUniformImage(3*EPD_HEIGHT, EPD_HEIGHT, color.Black)
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF); err != nil {
+		t.Fatal(err)
+	}
+	out := em.Image(emulator.RENDER_MONO)
+	if got := out.At(EPD_WIDTH/2, EPD_HEIGHT/2); got != color.Black {
+		t.Fatalf("center %v, want black", got)
+	}
+	for _, x := range []int{5, EPD_WIDTH - 5} {
+		if got := out.At(x, EPD_HEIGHT/2); got != color.White {
+			t.Fatalf("padding at x %d is %v, want white", x, got)
+		}
+	}
+}
+
+func TestEmulatedDisplayWithTone(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+
+	//dark photo: left half 30, right half 60
+	src := image.NewRGBA(image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.Gray{Y: 60}), image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, EPD_WIDTH/2, EPD_HEIGHT), image.NewUniform(color.Gray{Y: 30}), image.Point{}, draw.Src)
+	img := image.Image(src)
+
+	if err := e.Display(&img, MODE_MONO_DITHER_ON, WithTone(imageutil.ToneOptions{Brightness: 1})); err != nil {
+		t.Fatal(err)
+	}
+	out := em.Image(emulator.RENDER_MONO)
+	for _, x := range []int{10, EPD_WIDTH - 10} {
+		if got := out.At(x, EPD_HEIGHT/2); got != color.White {
+			t.Fatalf("full brightness left %v at x %d, want white", got, x)
+		}
+	}
+}
+
+// transparentLogo is a black square on a fully transparent panel sized
+// canvas.
+func transparentLogo() image.Image {
+	src := image.NewNRGBA(image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT))
+	draw.Draw(src, image.Rect(EPD_WIDTH/4, EPD_HEIGHT/4, 3*EPD_WIDTH/4, 3*EPD_HEIGHT/4), image.Black, image.Point{}, draw.Src)
+	return src
+}
+
+func TestEmulatedTransparentIsBackground(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+	img := transparentLogo()
+
+	for _, c := range []struct {
+		mode Mode
+		opts []DisplayOption
+		want color.Color
+	}{
+		{MODE_MONO_DITHER_OFF, nil, color.White},
+		{MODE_MONO_DITHER_ON, nil, color.White},
+		{MODE_MONO_DITHER_OFF, []DisplayOption{WithBackground(color.Black), WithForce()}, color.Black},
+	} {
+		if err := e.Display(&img, c.mode, c.opts...); err != nil {
+			t.Fatal(err)
+		}
+		out := em.Image(emulator.RENDER_MONO)
+		if got := out.At(10, 10); got != c.want {
+			t.Errorf("mode %v corner %v, want %v", c.mode, got, c.want)
+		}
+		if got := out.At(EPD_WIDTH/2, EPD_HEIGHT/2); got != color.Black {
+			t.Errorf("mode %v logo %v, want black", c.mode, got)
+		}
+	}
+
+	e.Setup_4Gray()
+	if err := e.Display_4Gray(&img); err != nil {
+		t.Fatal(err)
+	}
+	out := em.Image(emulator.RENDER_GRAY4)
+	if got := out.At(10, 10); got != emulator.Gray4Palette[3] {
+		t.Errorf("4 gray corner %v, want white", got)
+	}
+	if got := out.At(EPD_WIDTH/2, EPD_HEIGHT/2); got != emulator.Gray4Palette[0] {
+		t.Errorf("4 gray logo %v, want black", got)
+	}
+}
+
+// benchImage is a panel sized photo stand-in: smooth gradients with some
+// texture, as an RGBA like OpenImage and FitImage return.
+func benchImage() image.Image {
+	src := image.NewRGBA(image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT))
+	for y := 0; y < EPD_HEIGHT; y++ {
+		for x := 0; x < EPD_WIDTH; x++ {
+			v := uint8((x*255/EPD_WIDTH + y*255/EPD_HEIGHT + (x*y)%37) / 2)
+			src.SetRGBA(x, y, color.RGBA{R: v, G: v / 2, B: 255 - v, A: 0xff})
+		}
+	}
+	return src
+}
+
+func BenchmarkConvertImagetoMonochromeEPDTensor(b *testing.B) {
+	img := benchImage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ConvertImagetoMonochromeEPDTensor(&img)
+	}
+}
+
+func BenchmarkConvertImagetoMonochromeEPDTensorWithDither(b *testing.B) {
+	img := benchImage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ConvertImagetoMonochromeEPDTensorWithDither(&img)
+	}
+}
+
+func BenchmarkConvertImageto4GrayEPDTensor(b *testing.B) {
+	img := benchImage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ConvertImageto4GrayEPDTensor(&img)
+	}
+}
+
+func BenchmarkConvertImagetoMonochromeEPDTensorWithSauvola(b *testing.B) {
+	img := benchImage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ConvertImagetoMonochromeEPDTensorWith(&img, NewSauvola(0), Atkinson)
+	}
+}
+
+// BenchmarkDisplayPipeline is everything Display does before the SPI
+// transfer: fitting a larger photo, conversion and packing.
+func BenchmarkDisplayPipeline(b *testing.B) {
+	src := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			src.SetRGBA(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: uint8(x ^ y), A: 0xff})
+		}
+	}
+	img := image.Image(src)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		fitted := imageutil.OrientateAndFitImage(&img, EPD_WIDTH, EPD_HEIGHT)
+		mono, _ := ConvertImagetoMonochromeEPDTensorWithDither(&fitted)
+		Panel_2in7.GetEPDBuffer(mono)
+	}
+}
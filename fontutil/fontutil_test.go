@@ -3,6 +3,8 @@ package fontutil
 import (
 	"strings"
 	"testing"
+
+	"github.com/mipsmonsta/epd/imageutil"
 )
 
 func TestCheckIfErrorWhenContentTooWide(t *testing.T) {
@@ -58,6 +60,16 @@ func TestCheckIfErrorWhenSingleContentTooTall(t *testing.T) {
 	}
 }
 
+func TestTextImageIsOpaque(t *testing.T) {
+	img, _, err := PrintCenterWhiteTextBlackImage(12.0, 264, 176, "Hello", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imageutil.HasAlpha(img) {
+		t.Fatalf("text image has transparent pixels")
+	}
+}
+
 func stringSlicesAreEqual(sa, sb []string) bool{
 	if len(sa) != len(sb){
 		return false
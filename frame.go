@@ -0,0 +1,137 @@
+package epd
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+var ErrFrameSize = errors.New("frame size does not match the panel")
+
+var (
+	MonoPalette = color.Palette{
+		color.Black,
+		color.White,
+	}
+	// index is the 2 bit level on the panel, 0 black to 3 white
+	Gray4Palette = color.Palette{
+		color.Gray{Y: 0x00},
+		color.Gray{Y: 0x55},
+		color.Gray{Y: 0xAA},
+		color.Gray{Y: 0xFF},
+	}
+)
+
+// MonoFrame is a 1 bit image in the packed layout of GetEPDBuffer: portrait
+// rows of Stride bytes, leftmost pixel in the high bit, a set bit is white.
+// It implements draw.Image, so it can be drawn into with image/draw and
+// shown with Epd.DisplayMonoFrame without any conversion.
+type MonoFrame struct {
+	Pix    []byte
+	Stride int
+	Rect   image.Rectangle
+}
+
+// NewMonoFrame returns a white frame of width x height pixels.
+func NewMonoFrame(width, height int) *MonoFrame {
+	stride := (width + 7) / 8
+	pix := make([]byte, stride*height)
+	for i := range pix {
+		pix[i] = 0xff
+	}
+	return &MonoFrame{Pix: pix, Stride: stride, Rect: image.Rect(0, 0, width, height)}
+}
+
+func (f *MonoFrame) ColorModel() color.Model { return MonoPalette }
+
+func (f *MonoFrame) Bounds() image.Rectangle { return f.Rect }
+
+func (f *MonoFrame) At(x, y int) color.Color {
+	return MonoPalette[f.ColorIndexAt(x, y)]
+}
+
+// ColorIndexAt returns 0 for black and 1 for white.
+func (f *MonoFrame) ColorIndexAt(x, y int) uint8 {
+	if !(image.Point{x, y}.In(f.Rect)) {
+		return 0
+	}
+	x, y = x-f.Rect.Min.X, y-f.Rect.Min.Y
+	return (f.Pix[x/8+y*f.Stride] >> (7 - uint(x%8))) & 1
+}
+
+func (f *MonoFrame) Set(x, y int, c color.Color) {
+	f.SetColorIndex(x, y, uint8(MonoPalette.Index(c)))
+}
+
+func (f *MonoFrame) SetColorIndex(x, y int, index uint8) {
+	if !(image.Point{x, y}.In(f.Rect)) {
+		return
+	}
+	x, y = x-f.Rect.Min.X, y-f.Rect.Min.Y
+	mask := byte(0x80) >> uint(x%8)
+	if index&1 == 1 {
+		f.Pix[x/8+y*f.Stride] |= mask
+	} else {
+		f.Pix[x/8+y*f.Stride] &^= mask
+	}
+}
+
+// Gray4Frame is a 2 bit image in the packed layout of GetEPDBuffer_4Gray:
+// portrait rows of Stride bytes, four pixels per byte with the leftmost in
+// the high bits, level 0 black to 3 white.
+type Gray4Frame struct {
+	Pix    []byte
+	Stride int
+	Rect   image.Rectangle
+}
+
+// NewGray4Frame returns a white frame of width x height pixels.
+func NewGray4Frame(width, height int) *Gray4Frame {
+	stride := (width + 3) / 4
+	pix := make([]byte, stride*height)
+	for i := range pix {
+		pix[i] = 0xff
+	}
+	return &Gray4Frame{Pix: pix, Stride: stride, Rect: image.Rect(0, 0, width, height)}
+}
+
+func (f *Gray4Frame) ColorModel() color.Model { return Gray4Palette }
+
+func (f *Gray4Frame) Bounds() image.Rectangle { return f.Rect }
+
+func (f *Gray4Frame) At(x, y int) color.Color {
+	return Gray4Palette[f.ColorIndexAt(x, y)]
+}
+
+// ColorIndexAt returns the level, 0 black to 3 white.
+func (f *Gray4Frame) ColorIndexAt(x, y int) uint8 {
+	if !(image.Point{x, y}.In(f.Rect)) {
+		return 0
+	}
+	x, y = x-f.Rect.Min.X, y-f.Rect.Min.Y
+	return (f.Pix[x/4+y*f.Stride] >> (6 - 2*uint(x%4))) & 0x03
+}
+
+func (f *Gray4Frame) Set(x, y int, c color.Color) {
+	f.SetColorIndex(x, y, uint8(Gray4Palette.Index(c)))
+}
+
+func (f *Gray4Frame) SetColorIndex(x, y int, index uint8) {
+	if !(image.Point{x, y}.In(f.Rect)) {
+		return
+	}
+	x, y = x-f.Rect.Min.X, y-f.Rect.Min.Y
+	shift := 6 - 2*uint(x%4)
+	i := x/4 + y*f.Stride
+	f.Pix[i] = f.Pix[i]&^(0x03<<shift) | (index&0x03)<<shift
+}
+
+// NewMonoFrame returns a white frame the size of the panel.
+func (p *Panel) NewMonoFrame() *MonoFrame {
+	return NewMonoFrame(p.Width, p.Height)
+}
+
+// NewGray4Frame returns a white frame the size of the panel.
+func (p *Panel) NewGray4Frame() *Gray4Frame {
+	return NewGray4Frame(p.Width, p.Height)
+}
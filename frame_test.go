@@ -0,0 +1,147 @@
+package epd
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/mipsmonsta/epd/emulator"
+)
+
+func TestMonoFrameMatchesGetEPDBuffer(t *testing.T) {
+	f := Panel_2in7.NewMonoFrame()
+	draw.Draw(f, image.Rect(3, 5, 60, 90), image.Black, image.Point{}, draw.Src)
+
+	tensor := make([][]uint8, EPD_WIDTH)
+	for x := range tensor {
+		tensor[x] = make([]uint8, EPD_HEIGHT)
+		for y := range tensor[x] {
+			tensor[x][y] = 255
+			if (image.Point{x, y}.In(image.Rect(3, 5, 60, 90))) {
+				tensor[x][y] = 0
+			}
+		}
+	}
+	if !bytes.Equal(f.Pix, GetEPDBuffer(tensor)) {
+		t.Fatal("frame bytes differ from GetEPDBuffer")
+	}
+	if got := f.At(3, 5); got != color.Black {
+		t.Fatalf("At(3, 5) = %v, want black", got)
+	}
+	if got := f.At(60, 5); got != color.White {
+		t.Fatalf("At(60, 5) = %v, want white", got)
+	}
+}
+
+func TestGray4FrameLevels(t *testing.T) {
+	f := NewGray4Frame(8, 1)
+	for x := 0; x < 8; x++ {
+		f.Set(x, 0, color.Gray{Y: uint8(x * 36)})
+	}
+	want := []uint8{0, 0, 1, 1, 2, 2, 3, 3}
+	for x, w := range want {
+		if got := f.ColorIndexAt(x, 0); got != w {
+			t.Errorf("level at %d = %d, want %d", x, got, w)
+		}
+	}
+	if f.Pix[0] != 0x05 || f.Pix[1] != 0xAF {
+		t.Fatalf("packed % x, want 05 af", f.Pix)
+	}
+}
+
+func TestDisplayFramesOnEmulator(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+
+	mono := e.panel().NewMonoFrame()
+	draw.Draw(mono, image.Rect(0, 0, EPD_WIDTH, 10), image.Black, image.Point{}, draw.Src)
+	if err := e.DisplayMonoFrame(mono); err != nil {
+		t.Fatal(err)
+	}
+	out := em.Image(emulator.RENDER_MONO)
+	if out.At(0, 0) != color.Black || out.At(0, 10) != color.White {
+		t.Fatalf("mono frame not shown as drawn")
+	}
+
+	e.Setup_4Gray()
+	gray := e.panel().NewGray4Frame()
+	for y := 0; y < EPD_HEIGHT; y++ {
+		for x := 0; x < EPD_WIDTH; x++ {
+			gray.SetColorIndex(x, y, uint8(y*4/EPD_HEIGHT))
+		}
+	}
+	if err := e.DisplayGray4Frame(gray); err != nil {
+		t.Fatal(err)
+	}
+	out = em.Image(emulator.RENDER_GRAY4)
+	for level := 0; level < 4; level++ {
+		y := level*EPD_HEIGHT/4 + 5
+		if got := out.At(0, y); got != emulator.Gray4Palette[level] {
+			t.Errorf("band %d shows %v", level, got)
+		}
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
+
+func TestDisplayFrameWrongSize(t *testing.T) {
+	e, _ := newFakeEpd()
+	if err := e.DisplayMonoFrame(NewMonoFrame(EPD_HEIGHT, EPD_WIDTH)); !errors.Is(err, ErrFrameSize) {
+		t.Fatalf("got %v, want ErrFrameSize", err)
+	}
+}
+
+func TestDisplayShortFrame(t *testing.T) {
+	e, _ := newFakeEpd()
+	mono := NewMonoFrame(EPD_WIDTH, EPD_HEIGHT)
+	mono.Pix = mono.Pix[:10] //hand-built, right bounds but too few bytes
+	if err := e.DisplayMonoFrame(mono); !errors.Is(err, ErrFrameSize) {
+		t.Fatalf("mono: got %v, want ErrFrameSize", err)
+	}
+
+	e.Setup_4Gray()
+	gray := NewGray4Frame(EPD_WIDTH, EPD_HEIGHT)
+	gray.Pix = gray.Pix[:len(gray.Pix)-1]
+	if err := e.DisplayGray4Frame(gray); !errors.Is(err, ErrFrameSize) {
+		t.Fatalf("gray4: got %v, want ErrFrameSize", err)
+	}
+}
+
+func TestDisplayBuffersMatchImagePath(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+
+	src := image.NewRGBA(image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, EPD_WIDTH/2, EPD_HEIGHT), image.Black, image.Point{}, draw.Src)
+	img := image.Image(src)
+	e.Display(&img, MODE_MONO_DITHER_OFF)
+	want := append([]byte(nil), em.Plane(0x13)...)
+
+	tensor, _ := ConvertImagetoMonochromeEPDTensor(&img)
+	e.Clear()
+	if err := e.DisplayMonoBuffer(GetEPDBuffer(tensor)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(em.Plane(0x13), want) {
+		t.Fatal("buffer path shows a different frame than Display")
+	}
+
+	if err := e.DisplayMonoBuffer(make([]byte, 10)); !errors.Is(err, ErrBufferSize) {
+		t.Fatalf("short mono buffer: %v", err)
+	}
+	e.Setup_4Gray()
+	if err := e.DisplayGray4Buffer(make([]byte, Panel_2in7.MonoBufferSize())); !errors.Is(err, ErrBufferSize) {
+		t.Fatalf("mono sized gray4 buffer: %v", err)
+	}
+	if err := e.DisplayGray4Buffer(NewGray4Frame(EPD_WIDTH, EPD_HEIGHT).Pix); err != nil {
+		t.Fatal(err)
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
@@ -0,0 +1,92 @@
+package epd
+
+import (
+	"bytes"
+	"time"
+)
+
+// GhostingPolicy decides when fast and partial updates are replaced by a
+// full refresh to clear the ghosting they leave. The zero value never
+// intervenes.
+type GhostingPolicy struct {
+	MaxUpdates int           //full refresh once this many fast/partial updates are on screen; 0 disables
+	MaxAge     time.Duration //full refresh when the last one is older than this and updates followed it; 0 disables
+	DeepClean  bool          //flash black then white before that full refresh
+}
+
+// GhostingStats counts refreshes since Setup.
+type GhostingStats struct {
+	Updates       int       //fast and partial updates since the last full refresh
+	LastFull      time.Time //last full refresh, or Setup before the first one
+	FullRefreshes int
+	DeepCleans    int
+	Forced        int //full refreshes the policy put in place of fast/partial ones
+}
+
+func (e *Epd) now() time.Time {
+	if e.clock != nil {
+		return e.clock()
+	}
+	return time.Now()
+}
+
+// GhostingStats returns the refresh counters the policy works from.
+func (e *Epd) GhostingStats() GhostingStats {
+	return e.ghost
+}
+
+// ghostingDue reports whether the next fast or partial update should be a
+// full refresh instead.
+func (e *Epd) ghostingDue() bool {
+	g := e.Ghosting
+	if e.ghost.Updates == 0 {
+		return false
+	}
+	if g.MaxUpdates > 0 && e.ghost.Updates >= g.MaxUpdates {
+		return true
+	}
+	return g.MaxAge > 0 && e.now().Sub(e.ghost.LastFull) >= g.MaxAge
+}
+
+// forceFull runs the deep clean the policy asks for, if any, before a fast
+// or partial update is turned into a full refresh. The caller's update and
+// the frame it was diffed against survive the black and white frames.
+func (e *Epd) forceFull() error {
+	e.ghost.Forced++
+	if !e.Ghosting.DeepClean {
+		return nil
+	}
+	update, prev := e.lastUpdate, append([]byte(nil), e.prevMono...)
+	if err := e.DeepClean(); err != nil {
+		return err
+	}
+	e.lastUpdate, e.prevMono = update, prev
+	return nil
+}
+
+func (e *Epd) noteFullRefresh() {
+	e.ghost.Updates = 0
+	e.ghost.LastFull = e.now()
+	e.ghost.FullRefreshes++
+}
+
+func (e *Epd) noteUpdate() {
+	e.ghost.Updates++
+}
+
+// DeepClean drives the whole panel black and then white with the full
+// waveform, which removes ghosting a single full refresh leaves behind.
+func (e *Epd) DeepClean() error {
+	p := e.panel()
+	if err := e.ready(STATE_MONO_READY); err != nil {
+		return err
+	}
+	size := p.MonoBufferSize()
+	for _, fill := range []byte{^p.WhiteByte(), p.WhiteByte()} {
+		if err := e.displayMono(bytes.Repeat([]byte{fill}, size), displayOptions{force: true}); err != nil {
+			return err
+		}
+	}
+	e.ghost.DeepCleans++
+	return nil
+}
@@ -0,0 +1,165 @@
+package epd
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+	"time"
+
+	"github.com/mipsmonsta/epd/emulator"
+)
+
+func TestGhostingPolicyForcesFullRefreshAfterN(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Ghosting = GhostingPolicy{MaxUpdates: 3}
+	e.Setup()
+	e.Clear()
+
+	frames := []image.Image{
+		newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.White),
+		newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black),
+	}
+	for i := 1; i <= 3; i++ {
+		e.Display(&frames[i%2], MODE_MONO_DITHER_OFF, WithRefresh(REFRESH_FAST))
+		if got := e.GhostingStats().Updates; got != i {
+			t.Fatalf("update %d counted as %d", i, got)
+		}
+	}
+	e.Display(&frames[0], MODE_MONO_DITHER_OFF, WithRefresh(REFRESH_FAST))
+	stats := e.GhostingStats()
+	if stats.Updates != 0 || stats.Forced != 1 || stats.FullRefreshes != 2 {
+		t.Fatalf("stats %+v after the 4th update, want a forced full refresh", stats)
+	}
+	if !bytes.Equal(em.Lut(0x22), lut_bw) {
+		t.Fatal("forced refresh did not use the full waveform")
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
+
+func TestGhostingPolicyMaxAgeWithDeepClean(t *testing.T) {
+	e, em := newEmulatedEpd()
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	e.clock = func() time.Time { return now }
+	e.Ghosting = GhostingPolicy{MaxAge: 30 * time.Minute, DeepClean: true}
+	e.Setup()
+	e.Clear()
+
+	img := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black)
+	e.DisplayPartial(img, image.Rect(0, 0, 64, 32))
+	now = now.Add(10 * time.Minute)
+	e.DisplayPartial(img, image.Rect(0, 32, 64, 64))
+	if e.GhostingStats().Updates != 2 || em.PartialRefreshes() != 2 {
+		t.Fatalf("stats %+v, want 2 partial updates", e.GhostingStats())
+	}
+
+	now = now.Add(25 * time.Minute)
+	refreshes := em.Refreshes()
+	e.DisplayPartial(img, image.Rect(0, 64, 64, 96))
+	stats := e.GhostingStats()
+	if stats.DeepCleans != 1 || stats.Updates != 0 || !stats.LastFull.Equal(now) {
+		t.Fatalf("stats %+v, want a deep clean and a full refresh", stats)
+	}
+	if got := em.Refreshes() - refreshes; got != 3 {
+		t.Fatalf("%d full refreshes, want black, white and the frame", got)
+	}
+	if em.PartialRefreshes() != 2 {
+		t.Fatal("partial refresh sent although a full one was due")
+	}
+	//the update reported is the caller's, diffed against the frame before the clean
+	want := Update{Changed: image.Rect(0, 64, 64, 96), Refresh: REFRESH_FULL, Forced: true}
+	if got := e.LastUpdate(); got != want {
+		t.Fatalf("LastUpdate %+v, want %+v", got, want)
+	}
+
+	//fast update turned full: same for Display
+	white := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.White)
+	e.Display(&white, MODE_MONO_DITHER_OFF, WithRefresh(REFRESH_FAST))
+	now = now.Add(time.Hour)
+	e.Display(&img, MODE_MONO_DITHER_OFF, WithRefresh(REFRESH_FAST))
+	want = Update{Changed: image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT), Refresh: REFRESH_FULL, Forced: true}
+	if got := e.LastUpdate(); got != want {
+		t.Fatalf("LastUpdate after Display %+v, want %+v", got, want)
+	}
+}
+
+func TestGhostingPolicyPartialKeepsScreen(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Ghosting = GhostingPolicy{MaxUpdates: 1}
+	e.Setup()
+	e.Clear()
+
+	band := image.NewGray(image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT))
+	draw.Draw(band, band.Rect, image.White, image.Point{}, draw.Src)
+	draw.Draw(band, image.Rect(0, 0, EPD_WIDTH, 32), image.Black, image.Point{}, draw.Src)
+	var img image.Image = band
+	e.Display(&img, MODE_MONO_DITHER_OFF)
+
+	black := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black)
+	e.DisplayPartial(black, image.Rect(0, 100, 64, 132))
+	refreshes := em.Refreshes()
+	e.DisplayPartial(black, image.Rect(0, 200, 64, 232))
+	if em.Refreshes()-refreshes != 1 || e.GhostingStats().Forced != 1 {
+		t.Fatalf("stats %+v, want a forced full refresh", e.GhostingStats())
+	}
+	if u := e.LastUpdate(); !u.Forced || u.Partial || u.Changed != image.Rect(0, 200, 64, 232) {
+		t.Fatalf("LastUpdate %+v, want the window forced to a full refresh", u)
+	}
+	out := em.Image(emulator.RENDER_MONO)
+	for _, p := range []image.Point{{100, 10}, {10, 110}, {10, 210}} {
+		if out.At(p.X, p.Y) != color.Black {
+			t.Errorf("pixel %v lost by the forced full refresh", p)
+		}
+	}
+	if out.At(100, 150) != color.White {
+		t.Error("forced full refresh drew outside the windows")
+	}
+}
+
+func TestGhostingPolicyPartialUnknownScreen(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Ghosting = GhostingPolicy{MaxUpdates: 1}
+	e.Setup() //no Clear, so the screen outside the window is unknown
+
+	black := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black)
+	e.DisplayPartial(black, image.Rect(0, 100, 64, 132))
+	e.DisplayPartial(black, image.Rect(0, 200, 64, 232))
+	if em.Refreshes() != 0 || em.PartialRefreshes() != 2 || e.GhostingStats().Forced != 0 {
+		t.Fatalf("stats %+v, want two partial refreshes and no full one", e.GhostingStats())
+	}
+	if u := e.LastUpdate(); !u.GhostingDue || u.Forced || !u.Partial {
+		t.Fatalf("LastUpdate %+v, want a partial update flagged as ghosting due", u)
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
+
+func TestGhostingPolicyMaxAgeCountsFromSetup(t *testing.T) {
+	e, _ := newEmulatedEpd()
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	e.clock = func() time.Time { return now }
+	e.Ghosting = GhostingPolicy{MaxAge: 30 * time.Minute}
+	e.Setup() //no Clear, so no full refresh yet
+
+	frames := []image.Image{
+		newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.White),
+		newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black),
+	}
+	for i := 0; i < 2; i++ {
+		now = now.Add(time.Minute)
+		e.Display(&frames[i], MODE_MONO_DITHER_OFF, WithRefresh(REFRESH_FAST))
+	}
+	if stats := e.GhostingStats(); stats.Forced != 0 || stats.Updates != 2 {
+		t.Fatalf("stats %+v, want 2 fast updates and none forced", stats)
+	}
+
+	now = now.Add(30 * time.Minute)
+	e.Display(&frames[0], MODE_MONO_DITHER_OFF, WithRefresh(REFRESH_FAST))
+	if stats := e.GhostingStats(); stats.Forced != 1 {
+		t.Fatalf("stats %+v, want a forced full refresh 32 minutes after Setup", stats)
+	}
+}
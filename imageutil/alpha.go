@@ -0,0 +1,38 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// HasAlpha reports whether img may hold pixels that are not fully opaque.
+// Images that cannot tell are assumed to.
+func HasAlpha(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return !o.Opaque()
+	}
+	return true
+}
+
+// Flatten composites img over a solid bg, white when bg is nil, and returns
+// the opaque result with its origin at 0, 0. Transparent areas take the
+// background instead of reading as black once the alpha is dropped.
+func Flatten(img image.Image, bg color.Color) *image.RGBA {
+	if bg == nil {
+		bg = color.White
+	}
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Over)
+	return dst
+}
+
+// onWhite is the gray level of a premultiplied luma with alpha a over white.
+func onWhite(luma, a uint8) uint8 {
+	if v := int(luma) + 255 - int(a); v < 255 {
+		return uint8(v)
+	}
+	return 255 //colors brighter than their alpha are not valid premultiplied
+}
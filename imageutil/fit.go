@@ -0,0 +1,105 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+	"golang.org/x/image/draw"
+)
+
+// ScaleMode is how an image is sized to the target in FitImageWith.
+type ScaleMode int
+
+const (
+	ScaleContain ScaleMode = iota // whole image visible, the rest padded
+	ScaleCover                    // target filled, the overflow cropped
+	ScaleStretch                  // target filled, aspect ratio ignored
+	ScaleCenter                   // original size, cropped or padded
+)
+
+// Alignment is where the image sits in the target when it is padded or
+// which part is kept when it is cropped.
+type Alignment int
+
+const (
+	AlignCenter Alignment = iota
+	AlignTop
+	AlignBottom
+	AlignLeft
+	AlignRight
+	AlignTopLeft
+	AlignTopRight
+	AlignBottomLeft
+	AlignBottomRight
+)
+
+// FitOptions set the scaling mode, alignment and padding color of
+// FitImageWith. The zero value contains the image, centered on white.
+// Unknown modes and alignments act like ScaleContain and AlignCenter.
+type FitOptions struct {
+	Mode    ScaleMode
+	Align   Alignment
+	Padding color.Color // nil means white
+}
+
+// offsets returns how far into the free space (0 start, 1 end) the image
+// is put horizontally and vertically.
+func (a Alignment) offsets() (fx, fy float64) {
+	fx, fy = 0.5, 0.5
+	switch a {
+	case AlignTop, AlignTopLeft, AlignTopRight:
+		fy = 0
+	case AlignBottom, AlignBottomLeft, AlignBottomRight:
+		fy = 1
+	}
+	switch a {
+	case AlignLeft, AlignTopLeft, AlignBottomLeft:
+		fx = 0
+	case AlignRight, AlignTopRight, AlignBottomRight:
+		fx = 1
+	}
+	return
+}
+
+// FitImageWith sizes img to toWidth x toHeight as o asks, on an opaque
+// canvas of the padding color.
+func FitImageWith(img *image.Image, toWidth int, toHeight int, o FitOptions) image.Image {
+	src := *img
+	size := src.Bounds().Size()
+
+	w, h := size.X, size.Y
+	switch o.Mode {
+	case ScaleStretch:
+		w, h = toWidth, toHeight
+	case ScaleCenter:
+	default:
+		sx := float64(toWidth) / float64(size.X)
+		sy := float64(toHeight) / float64(size.Y)
+		s := math.Min(sx, sy)
+		if o.Mode == ScaleCover {
+			s = math.Max(sx, sy)
+		}
+		w = int(math.Max(1, math.Round(float64(size.X)*s)))
+		h = int(math.Max(1, math.Round(float64(size.Y)*s)))
+	}
+	if w != size.X || h != size.Y {
+		src = imaging.Resize(src, w, h, imaging.Lanczos)
+	}
+
+	padding := o.Padding
+	if padding == nil {
+		padding = color.White
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, toWidth, toHeight))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(padding), image.Point{}, draw.Src)
+
+	fx, fy := o.Align.offsets()
+	at := image.Point{
+		X: int(math.Round(float64(toWidth-w) * fx)),
+		Y: int(math.Round(float64(toHeight-h) * fy)),
+	}
+	draw.Draw(dst, image.Rectangle{Min: at, Max: at.Add(image.Pt(w, h))}, src, src.Bounds().Min, draw.Over)
+	return dst
+}
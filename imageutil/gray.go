@@ -0,0 +1,103 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"runtime"
+	"sync"
+)
+
+// ParallelRows calls fn on bands of rows covering 0 to height, one band
+// per CPU at most, and waits for them. Small images run on the caller's
+// goroutine.
+func ParallelRows(height int, fn func(y0, y1 int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > height/16 {
+		workers = height / 16 // not worth a goroutine for fewer rows
+	}
+	if workers <= 1 {
+		fn(0, height)
+		return
+	}
+	band := (height + workers - 1) / workers
+	var wg sync.WaitGroup
+	for y0 := 0; y0 < height; y0 += band {
+		y1 := y0 + band
+		if y1 > height {
+			y1 = height
+		}
+		wg.Add(1)
+		go func(y0, y1 int) {
+			defer wg.Done()
+			fn(y0, y1)
+		}(y0, y1)
+	}
+	wg.Wait()
+}
+
+// Luma is the gray level ConvertGreyScale and ToGray give an RGB color.
+func Luma(r, g, b uint8) uint8 {
+	return uint8((21*uint32(r) + 72*uint32(g) + 7*uint32(b) + 50) / 100)
+}
+
+// premultiply scales v by alpha a the way color.NRGBA.RGBA does.
+func premultiply(v, a uint8) uint8 {
+	return uint8(uint32(v) * 0x101 * (uint32(a) * 0x101) / 0xffff >> 8)
+}
+
+// ToGray returns the luminance of img as an image.Gray with its origin at
+// 0, 0. RGBA, NRGBA, Gray and YCbCr images are read straight from their
+// Pix slices; other types go through At. Pixels that are not opaque are
+// flattened onto white, as Flatten does by default.
+func ToGray(img image.Image) *image.Gray {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	out := image.NewGray(image.Rect(0, 0, w, h))
+
+	var row func(y int, dst []uint8)
+	switch src := img.(type) {
+	case *image.Gray:
+		row = func(y int, dst []uint8) {
+			i := src.PixOffset(b.Min.X, b.Min.Y+y)
+			copy(dst, src.Pix[i:i+w])
+		}
+	case *image.RGBA:
+		row = func(y int, dst []uint8) {
+			s := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := range dst {
+				dst[x] = onWhite(Luma(s[4*x], s[4*x+1], s[4*x+2]), s[4*x+3])
+			}
+		}
+	case *image.NRGBA:
+		row = func(y int, dst []uint8) {
+			s := src.Pix[src.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := range dst {
+				a := s[4*x+3]
+				dst[x] = onWhite(Luma(premultiply(s[4*x], a), premultiply(s[4*x+1], a), premultiply(s[4*x+2], a)), a)
+			}
+		}
+	case *image.YCbCr:
+		row = func(y int, dst []uint8) {
+			for x := range dst {
+				yi := src.YOffset(b.Min.X+x, b.Min.Y+y)
+				ci := src.COffset(b.Min.X+x, b.Min.Y+y)
+				r, g, bl := color.YCbCrToRGB(src.Y[yi], src.Cb[ci], src.Cr[ci])
+				dst[x] = Luma(r, g, bl)
+			}
+		}
+	default:
+		row = func(y int, dst []uint8) {
+			for x := range dst {
+				r, g, bl, a := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+				dst[x] = onWhite(Luma(uint8(r>>8), uint8(g>>8), uint8(bl>>8)), uint8(a>>8))
+			}
+		}
+	}
+
+	ParallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			row(y, out.Pix[y*out.Stride:y*out.Stride+w])
+		}
+	})
+	return out
+}
@@ -7,9 +7,7 @@ import (
 	"image/color"
 	"image/jpeg"
 	_ "image/png"
-	"log"
 	"os"
-	"sync"
 
 	"github.com/disintegration/imaging"
 	qrcode "github.com/skip2/go-qrcode"
@@ -84,24 +82,15 @@ func GetBackImage(pixels *[][]color.Color) image.Image {
 
 func UpsideDownImageTensor(pixels *[][]color.Color) image.Image {
 	p := *pixels
-	wg := sync.WaitGroup{}
 	rect := image.Rect(0, 0, len(p), len(p[0]))
 	newImage := image.NewRGBA(rect)
-	for x := 0; x < len(p); x++ {
-		for y := 0; y < len(p[0]); y++ {
-			if y > (len(p[0])/2 + 1) {
-				break
+	ParallelRows(len(p[0]), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < len(p); x++ {
+				newImage.Set(x, y, p[len(p)-x-1][len(p[0])-y-1])
 			}
-			wg.Add(1)
-			go func(x, y int) {
-				pix := p[x][y]
-				pix2 := p[len(p)-x-1][len(p[0])-y-1]
-				newImage.Set(x, y, pix2)
-				newImage.Set(len(p)-x-1, len(p[0])-y-1, pix)
-				wg.Done()
-			}(x, y)
 		}
-	}
+	})
 	return newImage
 }
 
@@ -111,13 +100,10 @@ func RotateImage90AntiClock(img *image.Image) image.Image {
 	return nrotated
 }
 
+// FitImage stretches img to exactly toWidth x toHeight, ignoring its aspect
+// ratio; it is FitImageWith with ScaleStretch.
 func FitImage(img *image.Image, toWidth int, toHeight int) image.Image {
-	srcImage := imaging.Fit(*img, toWidth, toHeight, imaging.Lanczos)
-	dstImage := image.NewRGBA(image.Rect(0, 0, toWidth, toHeight))
-
-	draw.CatmullRom.Scale(dstImage, dstImage.Rect, srcImage, srcImage.Rect, draw.Over, nil) //convert back to image.RGBA
-
-	return dstImage
+	return FitImageWith(img, toWidth, toHeight, FitOptions{Mode: ScaleStretch})
 }
 
 func EncodeImageAsJpeg(img image.Image, path string) error {
@@ -137,31 +123,17 @@ func EncodeImageAsJpeg(img image.Image, path string) error {
 func ConvertGreyScale(pixels *[][]color.Color) image.Image {
 
 	p := *pixels
-	wg := sync.WaitGroup{}
 	rect := image.Rect(0, 0, len(p), len(p[0]))
 	newImage := image.NewRGBA(rect)
-	for x := 0; x < len(p); x++ {
-		for y := 0; y < len(p[0]); y++ {
-			wg.Add(1)
-			go func(x, y int) {
-				pix := p[x][y]
-				originalColor, ok := color.RGBAModel.Convert(pix).(color.RGBA)
-				if !ok {
-					log.Fatalf("color.color conversion went wrong")
-				}
-				grey := uint8(float64(originalColor.R)*0.21 + float64(originalColor.G)*0.72 + float64(originalColor.B)*0.07)
-				col := color.RGBA{
-					grey,
-					grey,
-					grey,
-					originalColor.A,
-				}
-				newImage.Set(x, y, col)
-
-				wg.Done()
-			}(x, y)
+	ParallelRows(len(p[0]), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < len(p); x++ {
+				originalColor := color.RGBAModel.Convert(p[x][y]).(color.RGBA) //RGBAModel always yields color.RGBA
+				grey := onWhite(Luma(originalColor.R, originalColor.G, originalColor.B), originalColor.A) //flattened onto white
+				newImage.SetRGBA(x, y, color.RGBA{grey, grey, grey, 255})
+			}
 		}
-	}
+	})
 	return newImage
 }
 
@@ -181,16 +153,53 @@ func ScaleImage(srcImg *image.Image, toWidth int, toHeight int, scaleQuality int
 	return dstImage
 }
 
-func OrientateAndFitImage(img *image.Image, toWidth int, toHeight int) image.Image {
+// OrientateAndFitImage turns img 90 degrees anticlockwise when its
+// orientation differs from toWidth x toHeight, e.g. a landscape photo on a
+// portrait panel, and fits it with FitImageWith: contained and centered on
+// white unless fit is given.
+func OrientateAndFitImage(img *image.Image, toWidth int, toHeight int, fit ...FitOptions) image.Image {
 	size := (*img).Bounds().Size()
-	var result image.Image
-	if size.X > size.Y {
-		rotated := RotateImage90AntiClock(img) // to become potrait
-		result = FitImage(&rotated, toWidth, toHeight)
-	} else {
-		result = FitImage(img, toWidth, toHeight)
+	landscape := size.X > size.Y && toWidth < toHeight
+	portrait := size.X < size.Y && toWidth > toHeight
+	if landscape || portrait {
+		rotated := RotateImage90AntiClock(img)
+		return fitImage(&rotated, toWidth, toHeight, fit)
+	}
+	return fitImage(img, toWidth, toHeight, fit)
+}
+
+func fitImage(img *image.Image, toWidth int, toHeight int, fit []FitOptions) image.Image {
+	var o FitOptions
+	if len(fit) > 0 {
+		o = fit[0]
+	}
+	return FitImageWith(img, toWidth, toHeight, o)
+}
+
+// RotateImage turns img clockwise by degrees, which must be 0, 90, 180 or 270.
+func RotateImage(img *image.Image, degrees int) (image.Image, error) {
+	switch degrees {
+	case 0:
+		return *img, nil
+	case 90:
+		return imaging.Rotate270(*img), nil // imaging turns anticlockwise
+	case 180:
+		return imaging.Rotate180(*img), nil
+	case 270:
+		return imaging.Rotate90(*img), nil
+	}
+	return nil, fmt.Errorf("rotation of %d degrees, want 0, 90, 180 or 270", degrees)
+}
+
+// RotateAndFitImage turns img clockwise by degrees and fits the result into
+// toWidth x toHeight, without guessing the orientation like OrientateAndFitImage.
+// fit works as in OrientateAndFitImage.
+func RotateAndFitImage(img *image.Image, toWidth int, toHeight int, degrees int, fit ...FitOptions) (image.Image, error) {
+	rotated, err := RotateImage(img, degrees)
+	if err != nil {
+		return nil, err
 	}
-	return result
+	return fitImage(&rotated, toWidth, toHeight, fit), nil
 }
 
 func generateQRCodeImageFromURL(url string, size int) (image.Image, error) {
@@ -253,7 +262,7 @@ func PrintQRCodeWithWhiteBgImageWithURL(url string, width, height int, corner in
 	}
 
 	//draw QR on background
-	draw.Draw(rgba, image.Rect(topLeftQR.X, topLeftQR.Y, topLeftQR.X + scaleTo, topLeftQR.Y + scaleTo), qr_scaled, image.Point{X: 0, Y:0}, draw.Src)
+	draw.Draw(rgba, image.Rect(topLeftQR.X, topLeftQR.Y, topLeftQR.X + scaleTo, topLeftQR.Y + scaleTo), qr_scaled, image.Point{X: 0, Y:0}, draw.Over) //Over keeps the white under any soft edges
 	return rgba, nil
 	
 }
@@ -1,8 +1,13 @@
 package imageutil
 
 import (
+	"image"
+	"image/color"
+	"image/draw"
 	"image/png"
 	"os"
+	"runtime"
+	"sync"
 	"testing"
 )
 
@@ -18,7 +23,7 @@ func TestOpenImageIntoTensorAndBack(t *testing.T) {
 	}
 
 	outImg := GetBackImage(&pixels)
-	
+
 	err = EncodeImageAsJpeg(outImg, "./test/test_out.jpg")
 	if err != nil {
 		t.Fatal(err)
@@ -38,7 +43,7 @@ func TestOpenImageIntoTensorAndBack(t *testing.T) {
 
 }
 
-func TestCovertImageIntoGreyscale(t *testing.T){
+func TestCovertImageIntoGreyscale(t *testing.T) {
 	img, err := OpenImage("./test/test.jpg")
 	if err != nil {
 		t.Fatal(err)
@@ -50,7 +55,7 @@ func TestCovertImageIntoGreyscale(t *testing.T){
 	}
 
 	outGrey := ConvertGreyScale(&pixels)
-	
+
 	err = EncodeImageAsJpeg(outGrey, "./test/test_grey_out.jpg")
 	if err != nil {
 		t.Fatal(err)
@@ -69,7 +74,7 @@ func TestCovertImageIntoGreyscale(t *testing.T){
 	}
 }
 
-func TestScaleImage(t *testing.T){
+func TestScaleImage(t *testing.T) {
 	img, err := OpenImage("./test/test.jpg")
 	if err != nil {
 		t.Fatal(err)
@@ -78,7 +83,7 @@ func TestScaleImage(t *testing.T){
 	toWidth := (img.Bounds().Max.X - img.Bounds().Min.X) / 2
 	toHeight := (img.Bounds().Max.Y - img.Bounds().Min.Y) / 2
 	outScaled := ScaleImage(&img, toWidth, toHeight, ScaleBestQ)
-	
+
 	err = EncodeImageAsJpeg(outScaled, "./test/test_scale_halved_out.jpg")
 	if err != nil {
 		t.Fatal(err)
@@ -97,20 +102,19 @@ func TestScaleImage(t *testing.T){
 	}
 }
 
-func TestUpsideDownImage(t *testing.T){
+func TestUpsideDownImage(t *testing.T) {
 	img, err := OpenImage("./test/test.jpg")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-
 	pixels := GetImageTensor(img)
 	if pixels[0] == nil {
 		t.Fatalf("tensor is empty; failed to convert from image")
 	}
 
 	outUpsideDown := UpsideDownImageTensor(&pixels)
-	
+
 	err = EncodeImageAsJpeg(outUpsideDown, "./test/test_upside_down_out.jpg")
 	if err != nil {
 		t.Fatal(err)
@@ -138,7 +142,7 @@ func TestUpsideDownImage(t *testing.T){
 	}
 
 	outUpsideDownOddY := UpsideDownImageTensor(&pixels)
-	
+
 	err = EncodeImageAsJpeg(outUpsideDownOddY, "./test/test_upside_down_oddY_out.jpg")
 	if err != nil {
 		t.Fatal(err)
@@ -149,7 +153,6 @@ func TestUpsideDownImage(t *testing.T){
 		t.Fatal(err)
 	}
 
-
 	fi, _ = f.Stat()
 	realSize = fi.Size() > 0
 	if realSize == false {
@@ -159,14 +162,14 @@ func TestUpsideDownImage(t *testing.T){
 	defer f.Close()
 }
 
-func TestRotate90Image(t *testing.T){
+func TestRotate90Image(t *testing.T) {
 	img, err := OpenImage("./test/test.jpg")
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	outRotated := RotateImage90AntiClock(&img)
-	
+
 	err = EncodeImageAsJpeg(outRotated, "./test/test_rotated_out.jpg")
 	if err != nil {
 		t.Fatal(err)
@@ -185,15 +188,14 @@ func TestRotate90Image(t *testing.T){
 	}
 }
 
-
-func TestFitImage(t *testing.T){
+func TestFitImage(t *testing.T) {
 	img, err := OpenImage("./test/test.jpg")
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	outFitted := FitImage(&img, 264, 176)
-	
+
 	err = EncodeImageAsJpeg(outFitted, "./test/test_Fitted_out.jpg")
 	if err != nil {
 		t.Fatal(err)
@@ -212,14 +214,14 @@ func TestFitImage(t *testing.T){
 	}
 }
 
-func TestOrientateAndFitImageLandscape(t *testing.T){
+func TestOrientateAndFitImageLandscape(t *testing.T) {
 	img, err := OpenImage("./test/test.jpg")
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	outOrientFitted := OrientateAndFitImage(&img, 176, 264)
-	
+
 	err = EncodeImageAsJpeg(outOrientFitted, "./test/test_orient_fit_land_out.jpg")
 	if err != nil {
 		t.Fatal(err)
@@ -238,14 +240,37 @@ func TestOrientateAndFitImageLandscape(t *testing.T){
 	}
 }
 
-func TestOrientateAndFitImagePortrait(t *testing.T){
+func TestOrientateAndFitImageLandscapeTarget(t *testing.T) {
+	//left half black
+	src := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, 200, 300), image.Black, image.Point{}, draw.Src)
+	img := image.Image(src)
+
+	out := OrientateAndFitImage(&img, 400, 300)
+	if r, _, _, _ := out.At(10, 150).RGBA(); r != 0 {
+		t.Errorf("landscape on landscape was turned: left is %d", r>>8)
+	}
+
+	//the same turned to portrait, black on top, is turned back
+	portrait, _ := RotateImage(&img, 90)
+	out = OrientateAndFitImage(&portrait, 400, 300)
+	if r, _, _, _ := out.At(10, 150).RGBA(); r != 0 {
+		t.Errorf("portrait on landscape: left is %d, want black", r>>8)
+	}
+	if r, _, _, _ := out.At(390, 150).RGBA(); r>>8 != 0xff {
+		t.Errorf("portrait on landscape: right is %d, want white", r>>8)
+	}
+}
+
+func TestOrientateAndFitImagePortrait(t *testing.T) {
 	img, err := OpenImage("./test/test_portrait.jpg")
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	outOrientFitted := OrientateAndFitImage(&img, 176, 264)
-	
+
 	err = EncodeImageAsJpeg(outOrientFitted, "./test/test_orient_fit_port_out.jpg")
 	if err != nil {
 		t.Fatal(err)
@@ -264,9 +289,9 @@ func TestOrientateAndFitImagePortrait(t *testing.T){
 	}
 }
 
-func TestDrawQRLowerRight(t *testing.T){
+func TestDrawQRLowerRight(t *testing.T) {
 	img, err := PrintQRCodeWithWhiteBgImageWithURL("https://www.arstechnica.com", 264, 176, QRLowerRightCorner, 5)
-	if err != nil{
+	if err != nil {
 		t.Errorf("error: %s\n", err)
 		return
 	}
@@ -279,9 +304,9 @@ func TestDrawQRLowerRight(t *testing.T){
 	png.Encode(w, img)
 }
 
-func TestDrawQRLowerLeft(t *testing.T){
+func TestDrawQRLowerLeft(t *testing.T) {
 	img, err := PrintQRCodeWithWhiteBgImageWithURL("https://www.arstechnica.com", 264, 176, QRLowerLeftCorner, 5)
-	if err != nil{
+	if err != nil {
 		t.Errorf("error: %s\n", err)
 		return
 	}
@@ -294,9 +319,9 @@ func TestDrawQRLowerLeft(t *testing.T){
 	png.Encode(w, img)
 }
 
-func TestDrawQRUpperLeft(t *testing.T){
+func TestDrawQRUpperLeft(t *testing.T) {
 	img, err := PrintQRCodeWithWhiteBgImageWithURL("https://www.arstechnica.com", 264, 176, QRUpperLeftCorner, 5)
-	if err != nil{
+	if err != nil {
 		t.Errorf("error: %s\n", err)
 		return
 	}
@@ -309,10 +334,9 @@ func TestDrawQRUpperLeft(t *testing.T){
 	png.Encode(w, img)
 }
 
-
-func TestDrawQRUpperRight(t *testing.T){
+func TestDrawQRUpperRight(t *testing.T) {
 	img, err := PrintQRCodeWithWhiteBgImageWithURL("https://www.arstechnica.com", 264, 176, QRUpperRightCorner, 5)
-	if err != nil{
+	if err != nil {
 		t.Errorf("error: %s\n", err)
 		return
 	}
@@ -325,9 +349,9 @@ func TestDrawQRUpperRight(t *testing.T){
 	png.Encode(w, img)
 }
 
-func TestDrawQRMiddle(t *testing.T){
+func TestDrawQRMiddle(t *testing.T) {
 	img, err := PrintQRCodeWithWhiteBgImageWithURL("https://www.arstechnica.com", 264, 176, QRMiddle, 5)
-	if err != nil{
+	if err != nil {
 		t.Errorf("error: %s\n", err)
 		return
 	}
@@ -338,4 +362,267 @@ func TestDrawQRMiddle(t *testing.T){
 	}
 	defer w.Close()
 	png.Encode(w, img)
-}
\ No newline at end of file
+}
+
+func TestRotateImageClockwise(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	src.Set(0, 0, color.Black) // top left marker
+	img := image.Image(src)
+
+	corners := map[int]image.Point{0: {0, 0}, 90: {1, 0}, 180: {3, 1}, 270: {0, 3}}
+	for degrees, want := range corners {
+		out, err := RotateImage(&img, degrees)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, _, _, a := out.At(want.X, want.Y).RGBA()
+		if r != 0 || a == 0 {
+			t.Errorf("%d degrees: marker not at %v", degrees, want)
+		}
+	}
+	if _, err := RotateImage(&img, 45); err == nil {
+		t.Errorf("45 degrees should fail")
+	}
+}
+
+func TestFitImageWithModes(t *testing.T) {
+	// 20 x 10 black image into a 10 x 10 target
+	src := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	draw.Draw(src, src.Bounds(), image.Black, image.Point{}, draw.Src)
+	img := image.Image(src)
+
+	isBlack := func(out image.Image, x, y int) bool {
+		r, _, _, _ := out.At(x, y).RGBA()
+		return r < 0x8000
+	}
+
+	contain := FitImageWith(&img, 10, 10, FitOptions{})
+	if isBlack(contain, 5, 0) || !isBlack(contain, 5, 5) || isBlack(contain, 5, 9) {
+		t.Errorf("contain should pad top and bottom with white")
+	}
+	top := FitImageWith(&img, 10, 10, FitOptions{Align: AlignTop})
+	if !isBlack(top, 5, 0) || isBlack(top, 5, 9) {
+		t.Errorf("contain aligned top should pad the bottom only")
+	}
+	cover := FitImageWith(&img, 10, 10, FitOptions{Mode: ScaleCover})
+	stretch := FitImageWith(&img, 10, 10, FitOptions{Mode: ScaleStretch})
+	for _, out := range []image.Image{cover, stretch} {
+		if !isBlack(out, 0, 0) || !isBlack(out, 9, 9) {
+			t.Errorf("cover and stretch should fill the target")
+		}
+	}
+	gray := FitImageWith(&img, 30, 30, FitOptions{Mode: ScaleCenter, Align: AlignBottomRight, Padding: color.Gray{Y: 0x80}})
+	if !isBlack(gray, 29, 29) || !isBlack(gray, 10, 20) || isBlack(gray, 9, 29) || isBlack(gray, 29, 19) {
+		t.Errorf("center should keep the size and sit bottom right")
+	}
+	if got := gray.At(0, 0).(color.RGBA); got.R != 0x80 || got.A != 0xff {
+		t.Errorf("padding %v, want opaque gray", got)
+	}
+}
+
+func grayAt(img image.Image, x, y int) uint8 {
+	return color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y
+}
+
+func TestAdjustTone(t *testing.T) {
+	// ramp from 100 to 150 across 51 pixels
+	src := image.NewGray(image.Rect(0, 0, 51, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 51; x++ {
+			src.SetGray(x, y, color.Gray{Y: uint8(100 + x)})
+		}
+	}
+	img := image.Image(src)
+
+	if out := AdjustTone(&img, ToneOptions{}); grayAt(out, 25, 0) != 125 {
+		t.Errorf("zero options changed mid gray to %d", grayAt(out, 25, 0))
+	}
+	levels := AdjustTone(&img, ToneOptions{AutoLevels: true})
+	if grayAt(levels, 0, 0) != 0 || grayAt(levels, 50, 0) != 255 {
+		t.Errorf("auto levels gave %d - %d, want 0 - 255", grayAt(levels, 0, 0), grayAt(levels, 50, 0))
+	}
+	equalized := AdjustTone(&img, ToneOptions{Equalize: EqualizeHistogram})
+	if v := grayAt(equalized, 25, 0); v < 120 || v > 135 {
+		t.Errorf("equalized middle %d, want about 128", v)
+	}
+	if v := grayAt(AdjustTone(&img, ToneOptions{Gamma: 2}), 25, 0); v <= 125 {
+		t.Errorf("gamma 2 should lighten mid tones, got %d", v)
+	}
+	if v := grayAt(AdjustTone(&img, ToneOptions{Brightness: -0.2}), 25, 0); v != 74 {
+		t.Errorf("brightness -0.2 gave %d, want 74", v)
+	}
+	contrast := AdjustTone(&img, ToneOptions{Contrast: 1})
+	if grayAt(contrast, 0, 0) >= 100 || grayAt(contrast, 50, 0) <= 150 {
+		t.Errorf("contrast should spread the ramp, got %d - %d", grayAt(contrast, 0, 0), grayAt(contrast, 50, 0))
+	}
+}
+
+func TestAdjustToneCLAHE(t *testing.T) {
+	// dark left half and bright right half, each with faint detail
+	src := image.NewGray(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := 20 + (x+y)%4
+			if x >= 32 {
+				v = 220 + (x+y)%4
+			}
+			src.SetGray(x, y, color.Gray{Y: uint8(v)})
+		}
+	}
+	img := image.Image(src)
+
+	out := AdjustTone(&img, ToneOptions{Equalize: EqualizeCLAHE, CLAHETiles: 4})
+	lo, hi := uint8(255), uint8(0)
+	for y := 0; y < 4; y++ {
+		v := grayAt(out, 8+y, 8)
+		if v < lo {
+			lo = v
+		}
+		if v > hi {
+			hi = v
+		}
+	}
+	if hi-lo < 6 { // the input detail spans 3 levels
+		t.Errorf("CLAHE should stretch the dark detail, range %d - %d", lo, hi)
+	}
+	if grayAt(out, 56, 56) < 128 {
+		t.Errorf("bright half should stay bright, got %d", grayAt(out, 56, 56))
+	}
+}
+
+func TestParallelRowsCoversEveryRowOnce(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	for _, height := range []int{0, 1, 15, 64, 264, 1001} {
+		var mu sync.Mutex
+		seen := make([]int, height)
+		ParallelRows(height, func(y0, y1 int) {
+			mu.Lock()
+			defer mu.Unlock()
+			for y := y0; y < y1; y++ {
+				seen[y]++
+			}
+		})
+		for y, n := range seen {
+			if n != 1 {
+				t.Fatalf("height %d: row %d visited %d times", height, y, n)
+			}
+		}
+	}
+}
+
+// genericImage hides the concrete type so ToGray takes its At path.
+type genericImage struct{ image.Image }
+
+func TestToGrayFastPathsMatchAt(t *testing.T) {
+	rgba := image.NewRGBA(image.Rect(3, 5, 43, 37))
+	nrgba := image.NewNRGBA(rgba.Rect)
+	gray := image.NewGray(rgba.Rect)
+	for y := rgba.Rect.Min.Y; y < rgba.Rect.Max.Y; y++ {
+		for x := rgba.Rect.Min.X; x < rgba.Rect.Max.X; x++ {
+			c := color.NRGBA{R: uint8(x * 6), G: uint8(y * 7), B: uint8(x * y), A: 0xff}
+			rgba.Set(x, y, c)
+			c.A = uint8(x * 9)
+			nrgba.SetNRGBA(x, y, c)
+			gray.SetGray(x, y, color.Gray{Y: uint8(x + y)})
+		}
+	}
+	ycbcr := image.NewYCbCr(rgba.Rect, image.YCbCrSubsampleRatio420)
+	for i := range ycbcr.Y {
+		ycbcr.Y[i] = uint8(i)
+	}
+	for i := range ycbcr.Cb {
+		ycbcr.Cb[i], ycbcr.Cr[i] = uint8(i*3), uint8(255-i)
+	}
+
+	for _, img := range []image.Image{rgba, nrgba, gray, ycbcr} {
+		fast, slow := ToGray(img), ToGray(genericImage{img})
+		if fast.Rect != image.Rect(0, 0, 40, 32) {
+			t.Fatalf("%T: bounds %v", img, fast.Rect)
+		}
+		for i := range fast.Pix {
+			if d := int(fast.Pix[i]) - int(slow.Pix[i]); d < -1 || d > 1 {
+				t.Fatalf("%T: pixel %d is %d, At gives %d", img, i, fast.Pix[i], slow.Pix[i])
+			}
+		}
+	}
+}
+
+func BenchmarkToGray(b *testing.B) {
+	img, err := OpenImage("./test/test_shiba.jpg")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ToGray(img)
+	}
+}
+
+func BenchmarkConvertGreyScale(b *testing.B) {
+	img, err := OpenImage("./test/test_portrait.jpg")
+	if err != nil {
+		b.Fatal(err)
+	}
+	fitted := FitImage(&img, 176, 264)
+	pixels := GetImageTensor(fitted)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ConvertGreyScale(&pixels)
+	}
+}
+
+func TestFlattenOntoBackground(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(2, 2, 6, 6))
+	src.SetNRGBA(2, 2, color.NRGBA{A: 0xff})
+	src.SetNRGBA(3, 2, color.NRGBA{A: 0x80})
+	if !HasAlpha(src) {
+		t.Fatalf("transparent NRGBA reported opaque")
+	}
+
+	white := Flatten(src, nil)
+	if white.Rect != image.Rect(0, 0, 4, 4) || HasAlpha(white) {
+		t.Fatalf("Flatten gave %v, opaque %v", white.Rect, white.Opaque())
+	}
+	if got := white.RGBAAt(0, 0); got != (color.RGBA{0, 0, 0, 0xff}) {
+		t.Errorf("opaque black became %v", got)
+	}
+	if got := white.RGBAAt(1, 0).R; got < 0x7e || got > 0x80 {
+		t.Errorf("half transparent black on white is %d, want about 0x7f", got)
+	}
+	if got := white.RGBAAt(3, 3); got != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("transparent became %v, want white", got)
+	}
+
+	red := Flatten(src, color.RGBA{R: 0xff, A: 0xff})
+	if got := red.RGBAAt(3, 3); got != (color.RGBA{R: 0xff, A: 0xff}) {
+		t.Errorf("transparent became %v, want the red background", got)
+	}
+}
+
+func TestToGrayTransparentIsWhite(t *testing.T) {
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	nrgba.SetNRGBA(1, 0, color.NRGBA{R: 0x40, G: 0x40, B: 0x40, A: 0xff})
+	rgba := Flatten(nrgba, color.Transparent) //keeps the alpha, premultiplied
+	for _, img := range []image.Image{nrgba, rgba, genericImage{nrgba}} {
+		g := ToGray(img)
+		if g.Pix[0] != 0xff || g.Pix[1] != 0x40 {
+			t.Errorf("%T: gray %v, want [255 64]", img, g.Pix)
+		}
+	}
+
+	pixels := GetImageTensor(nrgba)
+	grey := ConvertGreyScale(&pixels).(*image.RGBA)
+	if got := grey.RGBAAt(0, 0); got != (color.RGBA{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("ConvertGreyScale made transparent %v, want opaque white", got)
+	}
+}
+
+func TestQRCodeIsOpaque(t *testing.T) {
+	img, err := PrintQRCodeWithWhiteBgImageWithURL("https://www.arstechnica.com", 264, 176, QRMiddle, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if HasAlpha(img) {
+		t.Fatalf("QR image has transparent pixels")
+	}
+}
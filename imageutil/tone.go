@@ -0,0 +1,226 @@
+package imageutil
+
+import (
+	"image"
+	"math"
+)
+
+// Equalization spreads the gray levels of an image over the whole range.
+type Equalization int
+
+const (
+	EqualizeNone      Equalization = iota
+	EqualizeHistogram              // one mapping for the whole image
+	EqualizeCLAHE                  // contrast limited, per tile, for uneven light
+)
+
+// ToneOptions shape the gray levels before thresholding or dithering. The
+// zero value changes nothing. Steps run in field order: levels,
+// equalization, brightness, contrast, gamma.
+type ToneOptions struct {
+	AutoLevels     bool    // stretch the darkest to black and the lightest to white
+	AutoLevelsClip float64 // fraction of pixels allowed to clip at each end, e.g. 0.01
+
+	Equalize   Equalization
+	CLAHETiles int     // tiles across and down; 0 means 8
+	CLAHEClip  float64 // histogram clip as a multiple of the mean bin; 0 means 2
+
+	Brightness float64 // -1 to 1, added to every level
+	Contrast   float64 // -1 flat, 0 unchanged, 1 twice the slope around mid gray
+	Gamma      float64 // above 1 lightens mid tones, below 1 darkens them; 0 means 1
+}
+
+// AdjustTone returns the luminance of img, as ToGray gives it, shaped as o
+// asks.
+func AdjustTone(img *image.Image, o ToneOptions) image.Image {
+	g := ToGray(*img)
+
+	if o.AutoLevels {
+		autoLevels(g, o.AutoLevelsClip)
+	}
+	switch o.Equalize {
+	case EqualizeHistogram:
+		equalize(g)
+	case EqualizeCLAHE:
+		tiles, clip := o.CLAHETiles, o.CLAHEClip
+		if tiles <= 0 {
+			tiles = 8
+		}
+		if clip <= 0 {
+			clip = 2
+		}
+		clahe(g, tiles, clip)
+	}
+	if o.Brightness != 0 || o.Contrast != 0 || (o.Gamma != 0 && o.Gamma != 1) {
+		applyCurve(g, toneCurve(o))
+	}
+	return g
+}
+
+func histogram(pix []uint8) (hist [256]int) {
+	for _, v := range pix {
+		hist[v]++
+	}
+	return
+}
+
+func applyCurve(g *image.Gray, curve [256]uint8) {
+	for i, v := range g.Pix {
+		g.Pix[i] = curve[v]
+	}
+}
+
+func clampLevel(v float64) uint8 {
+	return uint8(math.Max(0, math.Min(255, math.Round(v))))
+}
+
+// toneCurve folds brightness, contrast and gamma into one lookup table.
+func toneCurve(o ToneOptions) (curve [256]uint8) {
+	gamma := o.Gamma
+	if gamma <= 0 {
+		gamma = 1
+	}
+	for i := range curve {
+		v := float64(i)/255 + o.Brightness
+		v = (v-0.5)*(1+o.Contrast) + 0.5
+		v = math.Pow(math.Max(0, math.Min(1, v)), 1/gamma)
+		curve[i] = clampLevel(v * 255)
+	}
+	return
+}
+
+// autoLevels stretches the levels between the clip fractions to 0 - 255.
+func autoLevels(g *image.Gray, clip float64) {
+	hist := histogram(g.Pix)
+	limit := int(clip * float64(len(g.Pix)))
+	lo, hi := 0, 255
+	for count := 0; lo < 255; lo++ {
+		if count += hist[lo]; count > limit {
+			break
+		}
+	}
+	for count := 0; hi > 0; hi-- {
+		if count += hist[hi]; count > limit {
+			break
+		}
+	}
+	if hi <= lo {
+		return
+	}
+	var curve [256]uint8
+	for i := range curve {
+		curve[i] = clampLevel(float64(i-lo) * 255 / float64(hi-lo))
+	}
+	applyCurve(g, curve)
+}
+
+// equalizeCurve maps levels through the cumulative histogram so each
+// output level is about equally common.
+func equalizeCurve(hist [256]int) (curve [256]uint8) {
+	total, cdfMin, cdf := 0, 0, 0
+	for _, n := range hist {
+		total += n
+	}
+	for _, n := range hist {
+		if n > 0 {
+			cdfMin = n
+			break
+		}
+	}
+	if total == cdfMin {
+		for i := range curve {
+			curve[i] = uint8(i)
+		}
+		return
+	}
+	for i, n := range hist {
+		cdf += n
+		curve[i] = clampLevel(float64(cdf-cdfMin) * 255 / float64(total-cdfMin))
+	}
+	return
+}
+
+func equalize(g *image.Gray) {
+	applyCurve(g, equalizeCurve(histogram(g.Pix)))
+}
+
+// clahe equalizes each of tiles x tiles regions with its histogram clipped
+// at clip times the mean bin, and blends the mappings of the four nearest
+// tile centres so no tile edges show.
+func clahe(g *image.Gray, tiles int, clip float64) {
+	w, h := g.Rect.Dx(), g.Rect.Dy()
+	tx, ty := tiles, tiles
+	if tx > w {
+		tx = w
+	}
+	if ty > h {
+		ty = h
+	}
+	if tx == 0 || ty == 0 {
+		return
+	}
+
+	curves := make([][256]uint8, tx*ty)
+	for j := 0; j < ty; j++ {
+		for i := 0; i < tx; i++ {
+			x0, x1 := i*w/tx, (i+1)*w/tx
+			y0, y1 := j*h/ty, (j+1)*h/ty
+			var hist [256]int
+			for y := y0; y < y1; y++ {
+				for _, v := range g.Pix[y*g.Stride+x0 : y*g.Stride+x1] {
+					hist[v]++
+				}
+			}
+			clipHistogram(&hist, int(math.Max(1, clip*float64((x1-x0)*(y1-y0))/256)))
+			curves[i+j*tx] = equalizeCurve(hist)
+		}
+	}
+
+	tileW, tileH := float64(w)/float64(tx), float64(h)/float64(ty)
+	out := make([]uint8, len(g.Pix))
+	ParallelRows(h, func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			j0, j1, fy := tileBlend(y, tileH, ty)
+			for x := 0; x < w; x++ {
+				i0, i1, fx := tileBlend(x, tileW, tx)
+				v := g.Pix[x+y*g.Stride]
+				top := float64(curves[i0+j0*tx][v])*(1-fx) + float64(curves[i1+j0*tx][v])*fx
+				bottom := float64(curves[i0+j1*tx][v])*(1-fx) + float64(curves[i1+j1*tx][v])*fx
+				out[x+y*g.Stride] = clampLevel(top*(1-fy) + bottom*fy)
+			}
+		}
+	})
+	copy(g.Pix, out)
+}
+
+// tileBlend returns the tiles whose centres lie either side of pixel p and
+// the weight of the second.
+func tileBlend(p int, size float64, tiles int) (t0, t1 int, f float64) {
+	pos := (float64(p)+0.5)/size - 0.5
+	t0 = int(math.Floor(pos))
+	f = pos - float64(t0)
+	if t0 < 0 {
+		return 0, 0, 0
+	}
+	if t0 >= tiles-1 {
+		return tiles - 1, tiles - 1, 0
+	}
+	return t0, t0 + 1, f
+}
+
+// clipHistogram caps every bin at limit and spreads the excess evenly.
+func clipHistogram(hist *[256]int, limit int) {
+	excess := 0
+	for i, n := range hist {
+		if n > limit {
+			excess += n - limit
+			hist[i] = limit
+		}
+	}
+	for i := range hist {
+		hist[i] += excess / 256
+	}
+	for i := 0; i < excess%256; i++ {
+		hist[i*256/(excess%256)]++
+	}
+}
@@ -0,0 +1,185 @@
+package epd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// State is where the driver is in the panel's power lifecycle.
+type State int
+
+const (
+	STATE_UNINITIALISED State = 0 //before Setup, or after it failed
+	STATE_MONO_READY    State = 1 //after Setup
+	STATE_GRAY_READY    State = 2 //after Setup_4Gray
+	STATE_POWERED_OFF   State = 3 //booster off, registers kept; Wake powers on again
+	STATE_ASLEEP        State = 4 //deep sleep; Wake resets and sends the init sequence again
+	STATE_CLOSED        State = 5 //transport released; only Setup leaves it
+)
+
+var (
+	ErrNotInitialised = errors.New("panel not set up")
+	ErrPoweredOff     = errors.New("panel is powered off")
+	ErrAsleep         = errors.New("panel is asleep")
+	ErrClosed         = errors.New("panel is closed")
+	ErrWrongMode      = errors.New("panel is set up for another color mode")
+)
+
+func (s State) String() string {
+	switch s {
+	case STATE_UNINITIALISED:
+		return "uninitialised"
+	case STATE_MONO_READY:
+		return "mono ready"
+	case STATE_GRAY_READY:
+		return "gray ready"
+	case STATE_POWERED_OFF:
+		return "powered off"
+	case STATE_ASLEEP:
+		return "asleep"
+	case STATE_CLOSED:
+		return "closed"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+func (e *Epd) State() State {
+	return e.state
+}
+
+// stateErr is the error for calling something that needs a ready panel in
+// the current state.
+func (e *Epd) stateErr() error {
+	switch e.state {
+	case STATE_POWERED_OFF:
+		return ErrPoweredOff
+	case STATE_ASLEEP:
+		return ErrAsleep
+	case STATE_CLOSED:
+		return ErrClosed
+	}
+	return ErrNotInitialised
+}
+
+// ready checks that the panel can take a frame set up as want (mono or
+// gray), waking it first when AutoWake is set. STATE_UNINITIALISED for want
+// accepts either ready state.
+func (e *Epd) ready(want State) error {
+	if e.AutoWake && (e.state == STATE_POWERED_OFF || e.state == STATE_ASLEEP) {
+		if err := e.Wake(); err != nil {
+			return err
+		}
+	}
+	switch e.state {
+	case STATE_MONO_READY, STATE_GRAY_READY:
+		if want != STATE_UNINITIALISED && e.state != want {
+			return fmt.Errorf("%w: %v, want %v", ErrWrongMode, e.state, want)
+		}
+		return nil
+	}
+	return e.stateErr()
+}
+
+// initialise resets the controller and sends the init sequence for mode,
+// the part of Setup and Setup_4Gray that Wake repeats after deep sleep.
+func (e *Epd) initialise(mode State) error {
+	p := e.panel()
+	if err := e.Reset(); err != nil {
+		return err
+	}
+	e.loadedLut = lutUnknown
+	init := p.MonoInit
+	if mode == STATE_GRAY_READY {
+		init = p.Gray4Init
+	}
+	if err := e.runSequence(init); err != nil {
+		return err
+	}
+	e.state = mode //SetTemperature needs a ready panel to send the register
+	if err := e.restoreTemperature(); err != nil {
+		return err
+	}
+	if mode == STATE_MONO_READY {
+		return e.Set_lut()
+	}
+	return nil
+}
+
+// PowerOff turns off the booster without deep sleep. The controller keeps
+// its registers and RAM, so Wake only has to power on again.
+func (e *Epd) PowerOff() error {
+	p := e.panel()
+	switch e.state {
+	case STATE_POWERED_OFF:
+		return nil
+	case STATE_MONO_READY, STATE_GRAY_READY:
+	default:
+		return e.stateErr()
+	}
+	if len(p.PowerOff) == 0 {
+		return fmt.Errorf("power off on %s: %w", p.Name, ErrUnsupported)
+	}
+	if err := e.runSequence(p.PowerOff); err != nil {
+		return err
+	}
+	e.state = STATE_POWERED_OFF
+	return nil
+}
+
+// Sleep puts the controller in deep sleep, its lowest power state. The SPI
+// port stays open; Wake brings the panel back and Close releases it.
+func (e *Epd) Sleep() error {
+	switch e.state {
+	case STATE_ASLEEP:
+		return nil
+	case STATE_MONO_READY, STATE_GRAY_READY, STATE_POWERED_OFF:
+	default:
+		return e.stateErr()
+	}
+	if err := e.runSequence(e.panel().Sleep); err != nil {
+		return err
+	}
+	e.state = STATE_ASLEEP
+	e.loadedLut = lutUnknown //lost with the reset that ends deep sleep
+	return nil
+}
+
+// Wake returns a powered off or sleeping panel to the mode it was set up
+// in. It does nothing when the panel is already ready.
+func (e *Epd) Wake() error {
+	p := e.panel()
+	switch e.state {
+	case STATE_MONO_READY, STATE_GRAY_READY:
+		return nil
+	case STATE_POWERED_OFF:
+		if err := e.runSequence(p.PowerOn); err != nil {
+			return err
+		}
+		e.state = e.mode
+		return nil
+	case STATE_ASLEEP:
+		if err := e.initialise(e.mode); err != nil {
+			e.state = STATE_UNINITIALISED
+			return fmt.Errorf("wake: %w", err)
+		}
+		return nil
+	}
+	return e.stateErr()
+}
+
+// Close puts the panel to sleep if it is not already and releases the
+// transport. Only Setup can be called afterwards.
+func (e *Epd) Close() error {
+	switch e.state {
+	case STATE_CLOSED:
+		return ErrClosed
+	case STATE_MONO_READY, STATE_GRAY_READY, STATE_POWERED_OFF:
+		if err := e.Sleep(); err != nil {
+			return err
+		}
+		e.delay(2000 * time.Millisecond)
+	}
+	e.state = STATE_CLOSED
+	return e.Config.Destroy()
+}
@@ -0,0 +1,188 @@
+package epd
+
+import (
+	"errors"
+	"image/color"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mipsmonsta/epd/emulator"
+	"github.com/mipsmonsta/epd/epd_config"
+	"periph.io/x/conn/v3/gpio/gpioreg"
+	"periph.io/x/conn/v3/gpio/gpiotest"
+	"periph.io/x/conn/v3/spi"
+	"periph.io/x/conn/v3/spi/spireg"
+	"periph.io/x/conn/v3/spi/spitest"
+)
+
+func TestIllegalCallsReturnStateErrors(t *testing.T) {
+	e, em := newEmulatedEpd()
+	img := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black)
+
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF); !errors.Is(err, ErrNotInitialised) {
+		t.Fatalf("display before setup: %v", err)
+	}
+	if err := e.Wake(); !errors.Is(err, ErrNotInitialised) {
+		t.Fatalf("wake before setup: %v", err)
+	}
+
+	e.Setup()
+	if err := e.Display_4Gray(&img); !errors.Is(err, ErrWrongMode) {
+		t.Fatalf("4 gray in mono mode: %v", err)
+	}
+
+	e.PowerOff()
+	if em.PoweredOn() || e.State() != STATE_POWERED_OFF {
+		t.Fatalf("still powered, state %v", e.State())
+	}
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF); !errors.Is(err, ErrPoweredOff) {
+		t.Fatalf("display while off: %v", err)
+	}
+
+	e.Sleep()
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF); !errors.Is(err, ErrAsleep) {
+		t.Fatalf("display while asleep: %v", err)
+	}
+	if err := e.PowerOff(); !errors.Is(err, ErrAsleep) {
+		t.Fatalf("power off while asleep: %v", err)
+	}
+
+	e.Close()
+	if err := e.Clear(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("clear after close: %v", err)
+	}
+	if err := e.Close(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("second close: %v", err)
+	}
+	if !em.Closed() {
+		t.Fatal("transport still open")
+	}
+
+	if err := e.Setup(); err != nil || e.State() != STATE_MONO_READY {
+		t.Fatalf("setup after close: %v, state %v", err, e.State())
+	}
+}
+
+func TestWakeRestoresMode(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+	e.SetTemperature(3)
+	img := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black)
+
+	e.PowerOff()
+	if err := e.Wake(); err != nil {
+		t.Fatal(err)
+	}
+	if !em.PoweredOn() || e.State() != STATE_MONO_READY {
+		t.Fatalf("power on failed, state %v", e.State())
+	}
+
+	e.Sleep()
+	if !em.Asleep() {
+		t.Fatal("controller not in deep sleep")
+	}
+	e.Wake()
+	if em.Asleep() || e.State() != STATE_MONO_READY {
+		t.Fatalf("still asleep, state %v", e.State())
+	}
+	if c, _ := em.Temperature(); c != 3 {
+		t.Fatalf("temperature %d after wake, want 3", c)
+	}
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF); err != nil {
+		t.Fatal(err)
+	}
+	if em.Image(emulator.RENDER_MONO).At(0, 0) != color.Black {
+		t.Fatal("frame not shown after wake")
+	}
+
+	e.Setup_4Gray()
+	e.Sleep()
+	e.Wake()
+	if e.State() != STATE_GRAY_READY {
+		t.Fatalf("state %v after wake, want gray ready", e.State())
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
+
+func TestAutoWake(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.AutoWake = true
+	e.Setup()
+	e.Sleep()
+
+	img := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black)
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF); err != nil {
+		t.Fatal(err)
+	}
+	if em.Refreshes() == 0 || em.Image(emulator.RENDER_MONO).At(0, 0) != color.Black {
+		t.Fatal("sleeping panel not woken for display")
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
+
+// countedPort is a fake SPI port that counts itself out of portsOpen when
+// closed.
+type countedPort struct {
+	spitest.Record
+}
+
+func (p *countedPort) Close() error {
+	portsOpen--
+	return nil
+}
+
+var (
+	portsOpen, portsOpened int
+	registerFakeBus        sync.Once
+)
+
+// newFakeBusConfig returns an EpdConfig wired to fake pins and a fake SPI
+// port, registered once per test binary.
+func newFakeBusConfig(t *testing.T) *epd_config.EpdConfig {
+	registerFakeBus.Do(func() {
+		err := spireg.Register("EPDTEST", nil, -1, func() (spi.PortCloser, error) {
+			portsOpen++
+			portsOpened++
+			return &countedPort{}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, n := range []string{"EPDTEST_RST", "EPDTEST_DC", "EPDTEST_CS", "EPDTEST_BUSY"} {
+			if err := gpioreg.Register(&gpiotest.Pin{N: n, Num: -1}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	portsOpen, portsOpened = 0, 0
+	return epd_config.NewEpdConfig(
+		epd_config.WithPins("EPDTEST_RST", "EPDTEST_DC", "EPDTEST_CS", "EPDTEST_BUSY"),
+		epd_config.WithSPIPort("EPDTEST"),
+	)
+}
+
+func TestSleepSetupKeepsOnePortOpen(t *testing.T) {
+	e := &Epd{Config: newFakeBusConfig(t)}
+	e.sleep = func(time.Duration) {}
+
+	if err := e.Setup(); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+	if err := e.Sleep(); err != nil {
+		t.Fatalf("sleep: %v", err)
+	}
+	if err := e.Setup(); err != nil {
+		t.Fatalf("setup after sleep: %v", err)
+	}
+	if portsOpened != 2 || portsOpen != 1 {
+		t.Fatalf("%d ports opened, %d still open; want 2 and 1", portsOpened, portsOpen)
+	}
+	if err := e.Close(); err != nil || portsOpen != 0 {
+		t.Fatalf("close: %v, %d ports still open", err, portsOpen)
+	}
+}
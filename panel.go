@@ -0,0 +1,233 @@
+package epd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"periph.io/x/conn/v3/gpio"
+)
+
+type ColorMode int
+
+const (
+	COLOR_MONO     ColorMode = 0
+	COLOR_GRAY4    ColorMode = 1
+	COLOR_TRICOLOR ColorMode = 2 //black plane in OldDataCommand, red plane in NewDataCommand
+)
+
+var (
+	ErrUnknownPanel   = errors.New("unknown panel model")
+	ErrDuplicatePanel = errors.New("panel model already registered")
+	ErrUnsupported    = errors.New("not supported by this panel")
+)
+
+// Command is one controller command with its parameters. When WaitBusy is
+// set the driver polls BUSY after sending it.
+type Command struct {
+	Cmd      byte
+	Data     []byte
+	WaitBusy bool
+}
+
+// LutRegister is one waveform table and the register it is written to.
+type LutRegister struct {
+	Register byte
+	Data     []byte
+}
+
+// LutSet is the list of waveform tables uploaded together, in order.
+type LutSet []LutRegister
+
+// Panel describes one e-paper model: its resolution, the command sequences
+// to drive it and how pixels are packed into its RAM planes.
+type Panel struct {
+	Name   string
+	Width  int //pixels per row in panel (portrait) orientation
+	Height int
+
+	ColorModes []ColorMode
+
+	MonoInit  []Command //sent after reset by Setup
+	MonoLut   LutSet    //uploaded after MonoInit; empty when the waveform comes from OTP
+	FastLut   LutSet    //fewer phases for REFRESH_FAST; empty when the panel has none
+	Gray4Init []Command //sent after reset by Setup_4Gray
+	Gray4Lut  LutSet    //uploaded before each 4-gray refresh
+
+	FrameSetup      []Command //sent before the data planes, e.g. RAM address counters
+	OldDataCommand  byte      //plane for the previous frame; 0 when the controller has one plane
+	NewDataCommand  byte
+	MonoDataCommand byte //plane that takes a mono image; 0 means NewDataCommand
+	Refresh         []Command
+	Sleep           []Command                       //deep sleep; the controller needs a reset to wake
+	PowerOff        []Command                       //booster off, registers kept; empty when not supported
+	PowerOn         []Command                       //undoes PowerOff
+	Temperature     func(celsius float64) []Command //programs the controller's temperature register; nil when it has none
+
+	BusyLevel      gpio.Level //level of the BUSY pin while the controller is busy
+	InvertBits     bool       //1 bits mean ink rather than white
+	PartialRefresh bool       //supports the 0x14/0x15/0x16 partial window commands
+}
+
+var (
+	panelsMu sync.RWMutex
+	panels   = map[string]*Panel{}
+)
+
+// RegisterPanel makes a model available to NewEpd and LookupPanel.
+func RegisterPanel(p *Panel) error {
+	panelsMu.Lock()
+	defer panelsMu.Unlock()
+	if _, ok := panels[p.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrDuplicatePanel, p.Name)
+	}
+	panels[p.Name] = p
+	return nil
+}
+
+func mustRegisterPanel(p *Panel) {
+	if err := RegisterPanel(p); err != nil {
+		panic(err)
+	}
+}
+
+func LookupPanel(name string) (*Panel, error) {
+	panelsMu.RLock()
+	defer panelsMu.RUnlock()
+	p, ok := panels[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownPanel, name)
+	}
+	return p, nil
+}
+
+// PanelNames lists the registered models in alphabetical order.
+func PanelNames() []string {
+	panelsMu.RLock()
+	defer panelsMu.RUnlock()
+	names := make([]string, 0, len(panels))
+	for name := range panels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Panel) Supports(mode ColorMode) bool {
+	for _, m := range p.ColorModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// Stride is the number of bytes per row of a 1 bit plane.
+func (p *Panel) Stride() int {
+	return (p.Width + 7) / 8
+}
+
+func (p *Panel) MonoBufferSize() int {
+	return p.Stride() * p.Height
+}
+
+// Gray4BufferSize is the length of the 2 bit per pixel buffer that
+// GetEPDBuffer_4Gray produces.
+func (p *Panel) Gray4BufferSize() int {
+	return (p.Width + 3) / 4 * p.Height
+}
+
+func (p *Panel) monoDataCommand() byte {
+	if p.MonoDataCommand == 0 {
+		return p.NewDataCommand
+	}
+	return p.MonoDataCommand
+}
+
+// WhiteByte is a plane byte of eight white pixels.
+func (p *Panel) WhiteByte() byte {
+	if p.InvertBits {
+		return 0x00
+	}
+	return 0xFF
+}
+
+// GetEPDBuffer packs a monochrome tensor (0 black, 255 white) one bit per
+// pixel, most significant bit first. A tensor of Height x Width (landscape)
+// is rotated 90 degrees clockwise into the panel's portrait layout.
+func (p *Panel) GetEPDBuffer(monochrome [][]uint8) []byte {
+	imgWidth := len(monochrome)
+	imgHeight := len(monochrome[0])
+
+	buf := make([]byte, p.MonoBufferSize())
+	for i := range buf {
+		buf[i] = 0xff
+	}
+
+	stride := p.Stride()
+	clearBit := func(x, y int) {
+		buf[x/8+y*stride] &= ^(0x80 >> (x % 8)) // x bit will be 0 while rest are 1s whick allow masking
+	}
+	if imgWidth == p.Width && imgHeight == p.Height {
+		//image is verticals
+		for y := 0; y < imgHeight; y++ {
+			for x := 0; x < imgWidth; x++ {
+				if monochrome[x][y] == 0 {
+					clearBit(x, y)
+				}
+			}
+		}
+	} else if imgWidth == p.Height && imgHeight == p.Width {
+		//image is horizontal
+		for y := 0; y < imgHeight; y++ {
+			for x := 0; x < imgWidth; x++ {
+				if monochrome[x][y] == 0 {
+					clearBit(y, p.Height-x-1)
+				}
+			}
+		}
+	}
+
+	if p.InvertBits {
+		for i := range buf {
+			buf[i] = ^buf[i]
+		}
+	}
+	return buf
+}
+
+// GetEPDBuffer_4Gray packs a tensor of 2 bit levels (level << 6, 3 is white)
+// four pixels to a byte, leftmost pixel in the two most significant bits.
+func (p *Panel) GetEPDBuffer_4Gray(imgTensor [][]uint8) []byte {
+	imgWidth := len(imgTensor)
+	imgHeight := len(imgTensor[0])
+
+	buf := make([]byte, p.Gray4BufferSize())
+	for i := range buf {
+		buf[i] = 0xff
+	}
+
+	stride := (p.Width + 3) / 4
+	setLevel := func(x, y int, level uint8) {
+		shift := 6 - 2*uint(x%4)
+		index := x/4 + y*stride
+		buf[index] = buf[index]&^(0x03<<shift) | (level>>6)<<shift
+	}
+	if imgWidth == p.Width && imgHeight == p.Height {
+		//portrait
+		for y := 0; y < imgHeight; y++ {
+			for x := 0; x < imgWidth; x++ {
+				setLevel(x, y, imgTensor[x][y]&0xc0)
+			}
+		}
+	} else if imgWidth == p.Height && imgHeight == p.Width {
+		//landscape
+		for x := 0; x < imgWidth; x++ {
+			for y := 0; y < imgHeight; y++ {
+				setLevel(y, p.Height-x-1, imgTensor[x][y]&0xc0)
+			}
+		}
+	}
+	return buf
+}
@@ -0,0 +1,259 @@
+package epd
+
+import "periph.io/x/conn/v3/gpio"
+
+// Waveshare 2.7" HAT, IL91874 controller.
+const MODEL_2IN7 = "2in7"
+
+var (
+	lut_vcom_dc = []byte{
+		0x00, 0x00,
+		0x00, 0x1A, 0x1A, 0x00, 0x00, 0x01,
+		0x00, 0x0A, 0x0A, 0x00, 0x00, 0x08,
+		0x00, 0x0E, 0x01, 0x0E, 0x01, 0x10,
+		0x00, 0x0A, 0x0A, 0x00, 0x00, 0x08,
+		0x00, 0x04, 0x10, 0x00, 0x00, 0x05,
+		0x00, 0x03, 0x0E, 0x00, 0x00, 0x0A,
+		0x00, 0x23, 0x00, 0x00, 0x00, 0x01,
+	}
+
+	lut_ww = []byte{
+		0x90, 0x1A, 0x1A, 0x00, 0x00, 0x01,
+		0x40, 0x0A, 0x0A, 0x00, 0x00, 0x08,
+		0x84, 0x0E, 0x01, 0x0E, 0x01, 0x10,
+		0x80, 0x0A, 0x0A, 0x00, 0x00, 0x08,
+		0x00, 0x04, 0x10, 0x00, 0x00, 0x05,
+		0x00, 0x03, 0x0E, 0x00, 0x00, 0x0A,
+		0x00, 0x23, 0x00, 0x00, 0x00, 0x01,
+	}
+
+	// R22H    r
+	lut_bw = []byte{
+		0xA0, 0x1A, 0x1A, 0x00, 0x00, 0x01,
+		0x00, 0x0A, 0x0A, 0x00, 0x00, 0x08,
+		0x84, 0x0E, 0x01, 0x0E, 0x01, 0x10,
+		0x90, 0x0A, 0x0A, 0x00, 0x00, 0x08,
+		0xB0, 0x04, 0x10, 0x00, 0x00, 0x05,
+		0xB0, 0x03, 0x0E, 0x00, 0x00, 0x0A,
+		0xC0, 0x23, 0x00, 0x00, 0x00, 0x01,
+	}
+
+	// R23H    w
+	lut_bb = []byte{
+		0x90, 0x1A, 0x1A, 0x00, 0x00, 0x01,
+		0x40, 0x0A, 0x0A, 0x00, 0x00, 0x08,
+		0x84, 0x0E, 0x01, 0x0E, 0x01, 0x10,
+		0x80, 0x0A, 0x0A, 0x00, 0x00, 0x08,
+		0x00, 0x04, 0x10, 0x00, 0x00, 0x05,
+		0x00, 0x03, 0x0E, 0x00, 0x00, 0x0A,
+		0x00, 0x23, 0x00, 0x00, 0x00, 0x01,
+	}
+	// R24H    b
+	lut_wb = []byte{
+		0x90, 0x1A, 0x1A, 0x00, 0x00, 0x01,
+		0x20, 0x0A, 0x0A, 0x00, 0x00, 0x08,
+		0x84, 0x0E, 0x01, 0x0E, 0x01, 0x10,
+		0x10, 0x0A, 0x0A, 0x00, 0x00, 0x08,
+		0x00, 0x04, 0x10, 0x00, 0x00, 0x05,
+		0x00, 0x03, 0x0E, 0x00, 0x00, 0x0A,
+		0x00, 0x23, 0x00, 0x00, 0x00, 0x01,
+	}
+
+	//fast refresh: a single short phase that only drives pixels whose
+	//old and new bits differ, so the old plane must hold the previous frame
+	//R20H	vcom
+	lut_fast_vcom_dc = []byte{
+		0x00, 0x00,
+		0x00, 0x19, 0x01, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	//R21H	ww
+	lut_fast_ww = []byte{
+		0x00, 0x19, 0x01, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	//R22H	bw
+	lut_fast_bw = []byte{
+		0x80, 0x19, 0x01, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	//R23H	wb
+	lut_fast_wb = []byte{
+		0x40, 0x19, 0x01, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	//R24H	bb
+	lut_fast_bb = []byte{
+		0x00, 0x19, 0x01, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	//0-3 gray
+	gray_lut_vcom = []byte{
+		0x00, 0x00,
+		0x00, 0x0A, 0x00, 0x00, 0x00, 0x01,
+		0x60, 0x14, 0x14, 0x00, 0x00, 0x01,
+		0x00, 0x14, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x13, 0x0A, 0x01, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	//R21
+	gray_lut_ww = []byte{
+		0x40, 0x0A, 0x00, 0x00, 0x00, 0x01,
+		0x90, 0x14, 0x14, 0x00, 0x00, 0x01,
+		0x10, 0x14, 0x0A, 0x00, 0x00, 0x01,
+		0xA0, 0x13, 0x01, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	//R22H	r
+	gray_lut_bw = []byte{
+		0x40, 0x0A, 0x00, 0x00, 0x00, 0x01,
+		0x90, 0x14, 0x14, 0x00, 0x00, 0x01,
+		0x00, 0x14, 0x0A, 0x00, 0x00, 0x01,
+		0x99, 0x0C, 0x01, 0x03, 0x04, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	//R23H	w
+	gray_lut_wb = []byte{
+		0x40, 0x0A, 0x00, 0x00, 0x00, 0x01,
+		0x90, 0x14, 0x14, 0x00, 0x00, 0x01,
+		0x00, 0x14, 0x0A, 0x00, 0x00, 0x01,
+		0x99, 0x0B, 0x04, 0x04, 0x01, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	//R24H	b
+	gray_lut_bb = []byte{
+		0x80, 0x0A, 0x00, 0x00, 0x00, 0x01,
+		0x90, 0x14, 0x14, 0x00, 0x00, 0x01,
+		0x20, 0x14, 0x0A, 0x00, 0x00, 0x01,
+		0x50, 0x13, 0x01, 0x00, 0x00, 0x01,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+)
+
+var Panel_2in7 = &Panel{
+	Name:       MODEL_2IN7,
+	Width:      EPD_WIDTH,
+	Height:     EPD_HEIGHT,
+	ColorModes: []ColorMode{COLOR_MONO, COLOR_GRAY4},
+
+	MonoInit: []Command{
+		{Cmd: 0x01, Data: []byte{0x03, 0x00, 0x2b, 0x2b, 0x09}}, // POWER_SETTING: VDS_EN VDG_EN, VCOM_HV VGHL_LV, VDH, VDL, VDHR
+		{Cmd: 0x06, Data: []byte{0x07, 0x07, 0x17}},             // BOOSTER_SOFT_START
+		{Cmd: 0xF8, Data: []byte{0x60, 0xA5}},                   // Power optimization
+		{Cmd: 0xF8, Data: []byte{0x89, 0xA5}},                   // Power optimization
+		{Cmd: 0xF8, Data: []byte{0x90, 0x00}},                   // Power optimization
+		{Cmd: 0xF8, Data: []byte{0x93, 0x2A}},                   // Power optimization
+		{Cmd: 0xF8, Data: []byte{0xA0, 0xA5}},                   // Power optimization
+		{Cmd: 0xF8, Data: []byte{0xA1, 0x00}},                   // Power optimization
+		{Cmd: 0xF8, Data: []byte{0x73, 0x41}},                   // Power optimization
+		{Cmd: 0x16, Data: []byte{0x00}},                         // PARTIAL_DISPLAY_REFRESH
+		{Cmd: 0x04, WaitBusy: true},                             // POWER_ON
+		{Cmd: 0x00, Data: []byte{0xAF}},                         // PANEL_SETTING: KW-BF   KWR-AF    BWROTP 0f
+		{Cmd: 0x30, Data: []byte{0x3A}},                         // PLL_CONTROL: 3A 100HZ   29 150Hz 39 200HZ    31 171HZ
+		{Cmd: 0x50, Data: []byte{0x57}},                         // VCOM AND DATA INTERVAL SETTING
+		{Cmd: 0x82, Data: []byte{0x12}},                         // VCM_DC_SETTING_REGISTER
+	},
+	MonoLut: LutSet{
+		{0x20, lut_vcom_dc}, //vcom
+		{0x21, lut_ww},      //ww --
+		{0x22, lut_bw},      //bw r
+		{0x23, lut_bb},      //wb w
+		{0x24, lut_wb},      //bb b
+	},
+
+	FastLut: LutSet{
+		{0x20, lut_fast_vcom_dc},
+		{0x21, lut_fast_ww},
+		{0x22, lut_fast_bw},
+		{0x23, lut_fast_wb},
+		{0x24, lut_fast_bb},
+	},
+
+	Gray4Init: []Command{
+		{Cmd: 0x01, Data: []byte{0x03, 0x00, 0x2b, 0x2b}}, //POWER SETTING
+		{Cmd: 0x06, Data: []byte{0x07, 0x07, 0x17}},       //booster soft start A, B, C
+		{Cmd: 0xF8, Data: []byte{0x60, 0xA5}},             //boost??
+		{Cmd: 0xF8, Data: []byte{0x89, 0xA5}},             //boost??
+		{Cmd: 0xF8, Data: []byte{0x90, 0x00}},             //boost??
+		{Cmd: 0xF8, Data: []byte{0x93, 0x2A}},             //boost??
+		{Cmd: 0xF8, Data: []byte{0xa0, 0xa5}},             //boost??
+		{Cmd: 0xF8, Data: []byte{0xa1, 0x00}},             //boost??
+		{Cmd: 0xF8, Data: []byte{0x73, 0x41}},             //boost??
+		{Cmd: 0x16, Data: []byte{0x00}},
+		{Cmd: 0x04, WaitBusy: true},
+		{Cmd: 0x00, Data: []byte{0xbf}},                   //panel setting: KW-BF   KWR-AF	BWROTP 0f
+		{Cmd: 0x30, Data: []byte{0x90}},                   //PLL setting: 100hz
+		{Cmd: 0x61, Data: []byte{0x00, 0xb0, 0x01, 0x08}}, //resolution setting: 176, 264
+		{Cmd: 0x82, Data: []byte{0x12}},                   //vcom_DC setting
+		{Cmd: 0x50, Data: []byte{0x57}},                   ///VCOM AND DATA INTERVAL SETTING
+	},
+	Gray4Lut: LutSet{
+		{0x20, gray_lut_vcom},
+		{0x21, gray_lut_ww}, //red not use
+		{0x22, gray_lut_bw}, //bw r
+		{0x23, gray_lut_wb}, //wb w
+		{0x24, gray_lut_bb}, //bb b
+		{0x25, gray_lut_ww}, //vcom
+	},
+
+	OldDataCommand: 0x10,
+	NewDataCommand: 0x13,
+	Refresh:        []Command{{Cmd: 0x12, WaitBusy: true}},
+	Sleep: []Command{
+		{Cmd: 0x50, Data: []byte{0xF7}},
+		{Cmd: 0x02},
+		{Cmd: 0x07, Data: []byte{0xA5}}, //deep sleep
+	},
+
+	PowerOff:       []Command{{Cmd: 0x02, WaitBusy: true}},
+	PowerOn:        []Command{{Cmd: 0x04, WaitBusy: true}},
+	Temperature:    il91874Temperature,
+	BusyLevel:      gpio.Low,
+	PartialRefresh: true,
+}
+
+func init() {
+	mustRegisterPanel(Panel_2in7)
+}
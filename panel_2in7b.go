@@ -0,0 +1,51 @@
+package epd
+
+import "periph.io/x/conn/v3/gpio"
+
+// Waveshare 2.7" HAT (B), black/white/red, IL91874 controller in KWR mode.
+// Plane 0x10 holds black and 0x13 red; a set bit is ink. The waveform is
+// read from OTP.
+const MODEL_2IN7B = "2in7b"
+
+var Panel_2in7b = &Panel{
+	Name:       MODEL_2IN7B,
+	Width:      EPD_WIDTH,
+	Height:     EPD_HEIGHT,
+	ColorModes: []ColorMode{COLOR_MONO, COLOR_TRICOLOR},
+
+	MonoInit: []Command{
+		{Cmd: 0x04, WaitBusy: true},                             // POWER_ON
+		{Cmd: 0x00, Data: []byte{0x8F}},                         // PANEL_SETTING: KWR mode, LUT from OTP
+		{Cmd: 0x30, Data: []byte{0x3A}},                         // PLL_CONTROL: 3A 100HZ   29 150Hz 39 200HZ    31 171HZ
+		{Cmd: 0x01, Data: []byte{0x03, 0x00, 0x2b, 0x2b, 0x09}}, // POWER_SETTING: VDS_EN VDG_EN, VCOM_HV VGHL_LV, VDH, VDL, VDHR
+		{Cmd: 0x06, Data: []byte{0x07, 0x07, 0x17}},             // BOOSTER_SOFT_START
+		{Cmd: 0xF8, Data: []byte{0x60, 0xA5}},                   // Power optimization
+		{Cmd: 0xF8, Data: []byte{0x89, 0xA5}},                   // Power optimization
+		{Cmd: 0xF8, Data: []byte{0x90, 0x00}},                   // Power optimization
+		{Cmd: 0xF8, Data: []byte{0x93, 0x2A}},                   // Power optimization
+		{Cmd: 0xF8, Data: []byte{0x73, 0x41}},                   // Power optimization
+		{Cmd: 0x82, Data: []byte{0x12}},                         // VCM_DC_SETTING_REGISTER
+		{Cmd: 0x50, Data: []byte{0x87}},                         // VCOM_AND_DATA_INTERVAL_SETTING: define by OTP
+		{Cmd: 0x16, Data: []byte{0x00}},                         // PARTIAL_DISPLAY_REFRESH
+	},
+
+	OldDataCommand:  0x10,
+	NewDataCommand:  0x13,
+	MonoDataCommand: 0x10,
+	Refresh:         []Command{{Cmd: 0x12, WaitBusy: true}},
+	Sleep: []Command{
+		{Cmd: 0x50, Data: []byte{0xF7}},
+		{Cmd: 0x02},
+		{Cmd: 0x07, Data: []byte{0xA5}}, //deep sleep
+	},
+
+	PowerOff:    []Command{{Cmd: 0x02, WaitBusy: true}},
+	PowerOn:     []Command{{Cmd: 0x04, WaitBusy: true}},
+	Temperature: il91874Temperature,
+	BusyLevel:   gpio.Low,
+	InvertBits:  true,
+}
+
+func init() {
+	mustRegisterPanel(Panel_2in7b)
+}
@@ -0,0 +1,111 @@
+package epd
+
+import "periph.io/x/conn/v3/gpio"
+
+// Waveshare 2.9" and 2.13" modules (V1), IL3820/IL3895 controllers. They
+// have a single RAM plane written with 0x24 and a 30 byte LUT in 0x32.
+const (
+	MODEL_2IN9  = "2in9"
+	MODEL_2IN13 = "2in13"
+)
+
+var (
+	lut_2in9_full = []byte{
+		0x50, 0xAA, 0x55, 0xAA, 0x11, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xFF, 0xFF, 0x1F, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	lut_2in13_full = []byte{
+		0x22, 0x55, 0xAA, 0x55, 0xAA, 0x55, 0xAA, 0x11, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x1E, 0x1E, 0x1E, 0x1E,
+		0x1E, 0x1E, 0x1E, 0x1E, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+)
+
+// il3820Init is the power-on sequence shared by both sizes.
+func il3820Init(height int) []Command {
+	return []Command{
+		{Cmd: 0x01, Data: []byte{byte((height - 1) & 0xFF), byte((height - 1) >> 8), 0x00}}, // DRIVER_OUTPUT_CONTROL: GD = 0 SM = 0 TB = 0
+		{Cmd: 0x0C, Data: []byte{0xD7, 0xD6, 0x9D}},                                         // BOOSTER_SOFT_START_CONTROL
+		{Cmd: 0x2C, Data: []byte{0xA8}},                                                     // WRITE_VCOM_REGISTER
+		{Cmd: 0x3A, Data: []byte{0x1A}},                                                     // SET_DUMMY_LINE_PERIOD: 4 dummy lines per gate
+		{Cmd: 0x3B, Data: []byte{0x08}},                                                     // SET_GATE_TIME: 2us per line
+		{Cmd: 0x11, Data: []byte{0x03}},                                                     // DATA_ENTRY_MODE_SETTING: X increment Y increment
+	}
+}
+
+// il3820FrameSetup sets the RAM window to the whole panel and the address
+// counters to the top left corner.
+func il3820FrameSetup(width, height int) []Command {
+	return []Command{
+		{Cmd: 0x44, Data: []byte{0x00, byte((width - 1) >> 3)}},                                   // SET_RAM_X_ADDRESS_START_END_POSITION
+		{Cmd: 0x45, Data: []byte{0x00, 0x00, byte((height - 1) & 0xFF), byte((height - 1) >> 8)}}, // SET_RAM_Y_ADDRESS_START_END_POSITION
+		{Cmd: 0x4E, Data: []byte{0x00}},                                                           // SET_RAM_X_ADDRESS_COUNTER
+		{Cmd: 0x4F, Data: []byte{0x00, 0x00}, WaitBusy: true},                                     // SET_RAM_Y_ADDRESS_COUNTER
+	}
+}
+
+var il3820Refresh = []Command{
+	{Cmd: 0x22, Data: []byte{0xC4}}, // DISPLAY_UPDATE_CONTROL_2
+	{Cmd: 0x20},                     // MASTER_ACTIVATION
+	{Cmd: 0xFF, WaitBusy: true},     // TERMINATE_FRAME_READ_WRITE
+}
+
+// il3820PowerOff disables the analog block and the clock; il3820PowerOn
+// enables them again.
+var (
+	il3820PowerOff = []Command{
+		{Cmd: 0x22, Data: []byte{0x03}}, // DISPLAY_UPDATE_CONTROL_2
+		{Cmd: 0x20, WaitBusy: true},     // MASTER_ACTIVATION
+	}
+	il3820PowerOn = []Command{
+		{Cmd: 0x22, Data: []byte{0xC0}}, // DISPLAY_UPDATE_CONTROL_2
+		{Cmd: 0x20, WaitBusy: true},     // MASTER_ACTIVATION
+	}
+)
+
+var Panel_2in9 = &Panel{
+	Name:       MODEL_2IN9,
+	Width:      128,
+	Height:     296,
+	ColorModes: []ColorMode{COLOR_MONO},
+
+	MonoInit: il3820Init(296),
+	MonoLut:  LutSet{{0x32, lut_2in9_full}},
+
+	FrameSetup:     il3820FrameSetup(128, 296),
+	NewDataCommand: 0x24,
+	Refresh:        il3820Refresh,
+	Sleep:          []Command{{Cmd: 0x10, Data: []byte{0x01}}}, // DEEP_SLEEP_MODE
+
+	PowerOff:    il3820PowerOff,
+	PowerOn:     il3820PowerOn,
+	Temperature: il3820Temperature,
+	BusyLevel:   gpio.High,
+}
+
+var Panel_2in13 = &Panel{
+	Name:       MODEL_2IN13,
+	Width:      122,
+	Height:     250,
+	ColorModes: []ColorMode{COLOR_MONO},
+
+	MonoInit: il3820Init(250),
+	MonoLut:  LutSet{{0x32, lut_2in13_full}},
+
+	FrameSetup:     il3820FrameSetup(122, 250),
+	NewDataCommand: 0x24,
+	Refresh:        il3820Refresh,
+	Sleep:          []Command{{Cmd: 0x10, Data: []byte{0x01}}}, // DEEP_SLEEP_MODE
+
+	PowerOff:    il3820PowerOff,
+	PowerOn:     il3820PowerOn,
+	Temperature: il3820Temperature,
+	BusyLevel:   gpio.High,
+}
+
+func init() {
+	mustRegisterPanel(Panel_2in9)
+	mustRegisterPanel(Panel_2in13)
+}
@@ -0,0 +1,43 @@
+package epd
+
+import "periph.io/x/conn/v3/gpio"
+
+// Waveshare 4.2" module (V1), UC8176/IL0398 controller. Same command set as
+// the 2.7" but the waveform is read from OTP, so no LUTs are uploaded.
+const MODEL_4IN2 = "4in2"
+
+var Panel_4in2 = &Panel{
+	Name:       MODEL_4IN2,
+	Width:      400,
+	Height:     300,
+	ColorModes: []ColorMode{COLOR_MONO},
+
+	MonoInit: []Command{
+		{Cmd: 0x01, Data: []byte{0x03, 0x00, 0x2b, 0x2b}}, // POWER SETTING: VDS_EN VDG_EN, VCOM_HV VGHL_LV, VDH, VDL
+		{Cmd: 0x06, Data: []byte{0x17, 0x17, 0x17}},       // boost soft start
+		{Cmd: 0x04, WaitBusy: true},                       // POWER_ON
+		{Cmd: 0x00, Data: []byte{0x1f}},                   // panel setting: KW mode, LUT from OTP
+		{Cmd: 0x30, Data: []byte{0x3c}},                   // PLL setting
+		{Cmd: 0x61, Data: []byte{0x01, 0x90, 0x01, 0x2c}}, // resolution setting: 400, 300
+		{Cmd: 0x82, Data: []byte{0x28}},                   // vcom_DC setting
+		{Cmd: 0x50, Data: []byte{0x97}},                   // VCOM AND DATA INTERVAL SETTING: white border
+	},
+
+	OldDataCommand: 0x10,
+	NewDataCommand: 0x13,
+	Refresh:        []Command{{Cmd: 0x12, WaitBusy: true}},
+	Sleep: []Command{
+		{Cmd: 0x50, Data: []byte{0xF7}},
+		{Cmd: 0x02, WaitBusy: true},
+		{Cmd: 0x07, Data: []byte{0xA5}}, //deep sleep
+	},
+
+	PowerOff:    []Command{{Cmd: 0x02, WaitBusy: true}},
+	PowerOn:     []Command{{Cmd: 0x04, WaitBusy: true}},
+	Temperature: il91874Temperature,
+	BusyLevel:   gpio.Low,
+}
+
+func init() {
+	mustRegisterPanel(Panel_4in2)
+}
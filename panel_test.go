@@ -0,0 +1,135 @@
+package epd
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+	"time"
+
+	"github.com/mipsmonsta/epd/emulator"
+	"github.com/mipsmonsta/epd/epd_config"
+)
+
+func TestBuiltinPanelsAreRegistered(t *testing.T) {
+	for _, name := range []string{MODEL_2IN7, MODEL_2IN7B, MODEL_4IN2, MODEL_2IN9, MODEL_2IN13} {
+		p, err := LookupPanel(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Name != name {
+			t.Fatalf("lookup %q returned %q", name, p.Name)
+		}
+	}
+	if err := RegisterPanel(&Panel{Name: MODEL_2IN7}); !errors.Is(err, ErrDuplicatePanel) {
+		t.Fatalf("error %v, want ErrDuplicatePanel", err)
+	}
+}
+
+func TestNewEpdUnknownModel(t *testing.T) {
+	if _, err := NewEpd(&epd_config.FakeConfig{}, "7in5"); !errors.Is(err, ErrUnknownPanel) {
+		t.Fatalf("error %v, want ErrUnknownPanel", err)
+	}
+}
+
+func TestBufferSizeFollowsModel(t *testing.T) {
+	mono := make([][]uint8, Panel_2in13.Width)
+	for x := range mono {
+		mono[x] = make([]uint8, Panel_2in13.Height)
+	}
+	buf := Panel_2in13.GetEPDBuffer(mono)
+	if len(buf) != 16*250 {
+		t.Fatalf("2.13 buffer is %d bytes, want %d", len(buf), 16*250)
+	}
+	//122 pixels leave 6 padding bits, kept white, at the end of each row
+	if buf[15] != 0x3F || buf[16] != 0x00 {
+		t.Fatalf("row end %#x, next row %#x; want 0x3f and 0x00", buf[15], buf[16])
+	}
+}
+
+func TestIL3820PanelSequence(t *testing.T) {
+	fake := &epd_config.FakeConfig{BusyHigh: true, BusyReads: 2}
+	e, err := NewEpd(fake, MODEL_2IN9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.sleep = func(time.Duration) {}
+
+	if err := e.Setup(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.DataAfter(0x32, 0); !bytes.Equal(got, lut_2in9_full) {
+		t.Fatalf("2.9 LUT not uploaded")
+	}
+
+	img := newUniformImage(128, 296, color.White)
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(fake.DataAfter(0x24, 0)); got != 16*296 {
+		t.Fatalf("wrote %d bytes to RAM, want %d", got, 16*296)
+	}
+	if bytes.Contains(fake.Commands(), []byte{0x10, 0x13}) {
+		t.Fatalf("IL91874 plane commands sent to IL3820")
+	}
+	if err := e.Display_4Gray(&img); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("error %v, want ErrUnsupported", err)
+	}
+}
+
+func TestTriColorThreeBands(t *testing.T) {
+	em := emulator.New(EPD_WIDTH, EPD_HEIGHT)
+	e := &Epd{Config: em, Panel: Panel_2in7b}
+	e.sleep = func(time.Duration) {}
+	if err := e.Setup(); err != nil {
+		t.Fatal(err)
+	}
+
+	//top third black, middle red, bottom white
+	src := image.NewRGBA(image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT/3), image.Black, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, EPD_HEIGHT/3, EPD_WIDTH, 2*EPD_HEIGHT/3), &image.Uniform{color.RGBA{R: 0xE0, G: 0x20, B: 0x20, A: 0xFF}}, image.Point{}, draw.Src)
+	img := image.Image(src)
+
+	if err := e.Display(&img, MODE_TRICOLOR); err != nil {
+		t.Fatal(err)
+	}
+	out := em.Image(emulator.RENDER_TRICOLOR)
+	want := []color.Color{emulator.TriColorPalette[1], emulator.TriColorPalette[2], emulator.TriColorPalette[0]}
+	for i, w := range want {
+		y := i*EPD_HEIGHT/3 + EPD_HEIGHT/6
+		if got := out.At(EPD_WIDTH/2, y); got != w {
+			t.Errorf("band %d pixel %v, want %v", i, got, w)
+		}
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
+
+func TestTriColorTransparentIsWhite(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(src, image.Rect(0, 0, 4, 8), &image.Uniform{color.RGBA{R: 0xE0, G: 0x20, B: 0x20, A: 0xFF}}, image.Point{}, draw.Src)
+	img := image.Image(src)
+	black, red, err := ConvertImagetoTriColorEPDTensors(&img, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if black[6][4] != 255 || red[6][4] != 255 {
+		t.Fatalf("transparent pixel black %d red %d, want white", black[6][4], red[6][4])
+	}
+	if red[1][4] != 0 {
+		t.Fatalf("red pixel lost")
+	}
+}
+
+func TestTriColorUnsupported(t *testing.T) {
+	e, _ := newFakeEpd()
+	img := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.White)
+	if err := e.Display(&img, MODE_TRICOLOR); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("got %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mipsmonsta/epd"
+	"github.com/mipsmonsta/epd/emulator"
+	"github.com/mipsmonsta/epd/imageutil"
+)
+
+// Renders to emulated_mono.png instead of the HAT, handy on a laptop.
+func main() {
+	em := emulator.New(epd.EPD_WIDTH, epd.EPD_HEIGHT)
+	e := epd.Epd{
+		Config: em,
+	}
+	if err := e.Setup(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := e.Clear(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	img, err := imageutil.OpenImage("../imageutil/test/test_portrait.jpg")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := e.Display(&img, epd.MODE_MONO_DITHER_ON); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := em.SavePNG("emulated_mono.png", emulator.RENDER_MONO); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
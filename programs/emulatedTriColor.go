@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mipsmonsta/epd"
+	"github.com/mipsmonsta/epd/emulator"
+	"github.com/mipsmonsta/epd/imageutil"
+)
+
+// Renders the 2.7" (B) black/white/red output to emulated_tricolor.png.
+func main() {
+	em := emulator.New(epd.EPD_WIDTH, epd.EPD_HEIGHT)
+	e, err := epd.NewEpd(em, epd.MODEL_2IN7B)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := e.Setup(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	img, err := imageutil.OpenImage("../imageutil/test/test_portrait.jpg")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := e.Display(&img, epd.MODE_TRICOLOR); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err := em.SavePNG("emulated_tricolor.png", emulator.RENDER_TRICOLOR); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
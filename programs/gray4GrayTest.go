@@ -13,10 +13,16 @@ import (
 func main() {
 
 	e := epd.Epd{
-		Config: epd_config.EpdConfig{},
+		Config: &epd_config.EpdConfig{},
+	}
+	if err := e.Setup_4Gray(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := e.Clear(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	e.Setup_4Gray()
-	e.Clear()
 
 	img, err := imageutil.OpenImage("../imageutil/test/test.jpg")
 	if err != nil {
@@ -24,8 +30,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	e.Display_4Gray(&img)
+	if err := e.Display_4Gray(&img); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	e.Sleep()
+	if err := e.Close(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 }
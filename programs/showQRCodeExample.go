@@ -13,12 +13,20 @@ func main() {
 	}
 
 	e := epd.Epd{
-		Config: epd_config.EpdConfig{},
+		Config: &epd_config.EpdConfig{},
+	}
+	if err := e.Setup(); err != nil {
+		panic(err)
+	}
+	if err := e.Clear(); err != nil {
+		panic(err)
 	}
-	e.Setup()
-	e.Clear()
 
-	e.Display(&img, epd.MODE_MONO_DITHER_OFF)
+	if err := e.Display(&img, epd.MODE_MONO_DITHER_OFF); err != nil {
+		panic(err)
+	}
 
-	e.Sleep()
+	if err := e.Close(); err != nil {
+		panic(err)
+	}
 }
\ No newline at end of file
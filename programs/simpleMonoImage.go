@@ -12,10 +12,16 @@ import (
 func main() {
 
 	e := epd.Epd{
-		Config: epd_config.EpdConfig{},
+		Config: &epd_config.EpdConfig{},
+	}
+	if err := e.Setup(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := e.Clear(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	e.Setup()
-	e.Clear()
 
 	img, err := imageutil.OpenImage("../imageutil/test/test.jpg")
 	if err != nil {
@@ -23,8 +29,14 @@ func main() {
 		os.Exit(1)
 	}
 
-	e.Display(&img, epd.MODE_MONO_DITHER_ON)
+	if err := e.Display(&img, epd.MODE_MONO_DITHER_ON); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
-	e.Sleep()
+	if err := e.Close(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 
 }
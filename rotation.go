@@ -0,0 +1,59 @@
+package epd
+
+import (
+	"errors"
+	"fmt"
+	"image"
+
+	"github.com/mipsmonsta/epd/imageutil"
+)
+
+// Rotation is how far images are turned clockwise before they are fitted
+// onto the panel's frame.
+type Rotation int
+
+const (
+	ROTATE_AUTO Rotation = 0 //90 degrees anticlockwise when image and panel orientations differ
+	ROTATE_0    Rotation = 1
+	ROTATE_90   Rotation = 2 //clockwise
+	ROTATE_180  Rotation = 3 //e.g. a HAT mounted upside down
+	ROTATE_270  Rotation = 4
+)
+
+var ErrUnknownRotation = errors.New("unknown rotation")
+
+// degrees is the clockwise turn r gives an image of size on a panel of
+// panel pixels. Square images and panels are never turned by ROTATE_AUTO.
+func (r Rotation) degrees(size, panel image.Point) (int, error) {
+	switch r {
+	case ROTATE_AUTO:
+		landscape := size.X > size.Y && panel.X < panel.Y
+		portrait := size.X < size.Y && panel.X > panel.Y
+		if landscape || portrait {
+			return 270, nil
+		}
+		return 0, nil
+	case ROTATE_0, ROTATE_90, ROTATE_180, ROTATE_270:
+		return 90 * int(r-ROTATE_0), nil
+	}
+	return 0, fmt.Errorf("%w: %d", ErrUnknownRotation, r)
+}
+
+// fit rotates img by e.Rotation and fits it to the panel as o asks, the
+// first step of every Display mode.
+func (e *Epd) fit(img *image.Image, o displayOptions) (image.Image, error) {
+	p := e.panel()
+	if imageutil.HasAlpha(*img) {
+		var flat image.Image = imageutil.Flatten(*img, o.background)
+		img = &flat
+	}
+	degrees, err := e.Rotation.degrees((*img).Bounds().Size(), image.Pt(p.Width, p.Height))
+	if err != nil {
+		return nil, err
+	}
+	var fit imageutil.FitOptions
+	if o.fit != nil {
+		fit = *o.fit
+	}
+	return imageutil.RotateAndFitImage(img, p.Width, p.Height, degrees, fit)
+}
@@ -0,0 +1,164 @@
+package epd
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	ErrTemperatureBands  = errors.New("temperature bands are empty or out of order")
+	ErrTemperatureSensor = errors.New("temperature sensor failed")
+)
+
+// TemperatureBand is the waveform choice for temperatures below Below and
+// at or above the previous band's Below. Explicit Waveforms win; otherwise
+// the panel's built-in tables are used with their frame counts multiplied
+// by Scale, since colder particles need longer drive phases.
+type TemperatureBand struct {
+	Below     float64
+	Scale     float64
+	Waveforms map[WaveformSlot]*Waveform
+}
+
+// DefaultTemperatureBands stretch the waveforms below room temperature and
+// shorten them a little when it is hot.
+var DefaultTemperatureBands = []TemperatureBand{
+	{Below: 5, Scale: 2},
+	{Below: 15, Scale: 1.5},
+	{Below: 30, Scale: 1},
+	{Below: math.Inf(1), Scale: 0.8},
+}
+
+// Scaled returns a copy of w with every frame count multiplied by factor,
+// rounded and kept within 1 - 255 so no active phase disappears.
+func (w *Waveform) Scaled(factor float64) *Waveform {
+	out := &Waveform{Name: fmt.Sprintf("%s x%g", w.Name, factor)}
+	for _, l := range w.Luts {
+		data := append([]byte(nil), l.Data...)
+		groups := data
+		if l.Register == lutFirstReg {
+			groups = groups[lutVcomPrefix:]
+		}
+		for g := 0; g+lutGroupSize <= len(groups); g += lutGroupSize {
+			for i := g + 1; i < g+lutGroupSize-1; i++ { //frame counts, not level select or repeat
+				if groups[i] == 0 {
+					continue
+				}
+				groups[i] = byte(math.Max(1, math.Min(255, math.Round(float64(groups[i])*factor))))
+			}
+		}
+		out.Luts = append(out.Luts, LutRegister{Register: l.Register, Data: data})
+	}
+	return out
+}
+
+func validateBands(bands []TemperatureBand) error {
+	if len(bands) == 0 {
+		return ErrTemperatureBands
+	}
+	for i := 1; i < len(bands); i++ {
+		if !(bands[i].Below > bands[i-1].Below) {
+			return fmt.Errorf("%w: band %d", ErrTemperatureBands, i)
+		}
+	}
+	return nil
+}
+
+func (e *Epd) bands() []TemperatureBand {
+	if e.TemperatureBands == nil {
+		return DefaultTemperatureBands
+	}
+	return e.TemperatureBands
+}
+
+// bandFor is the index of the band covering celsius; warmer than the last
+// band uses the last one.
+func bandFor(bands []TemperatureBand, celsius float64) int {
+	for i, b := range bands {
+		if celsius < b.Below {
+			return i
+		}
+	}
+	return len(bands) - 1
+}
+
+// SetTemperature tells the controller the ambient temperature and picks
+// the waveforms of the matching band from TemperatureBands. The new tables
+// are uploaded before the next refresh, and Setup and Wake send the
+// temperature again after a reset. Before Setup it is only stored.
+func (e *Epd) SetTemperature(celsius float64) error {
+	p := e.panel()
+	bands := e.bands()
+	if err := validateBands(bands); err != nil {
+		return err
+	}
+	band := bands[bandFor(bands, celsius)]
+
+	luts := map[WaveformSlot]LutSet{}
+	if p.programmableLut() {
+		for _, slot := range []WaveformSlot{WAVEFORM_FULL, WAVEFORM_FAST, WAVEFORM_GRAY4} {
+			if w, ok := band.Waveforms[slot]; ok {
+				if err := w.ValidateFor(slot); err != nil {
+					return err
+				}
+				luts[slot] = w.Luts
+			} else if base := p.panelLut(slot); len(base) > 0 && band.Scale > 0 && band.Scale != 1 {
+				luts[slot] = (&Waveform{Luts: base}).Scaled(band.Scale).Luts
+			}
+		}
+	}
+
+	//only sent while the controller is up; Setup and Wake send it later
+	awake := e.state == STATE_MONO_READY || e.state == STATE_GRAY_READY || e.state == STATE_POWERED_OFF
+	if awake && p.Temperature != nil {
+		if err := e.runSequence(p.Temperature(celsius)); err != nil {
+			return fmt.Errorf("temperature: %w", err)
+		}
+	}
+	e.temperature, e.hasTemperature = celsius, true
+	e.bandLuts = luts
+	e.loadedLut = lutUnknown //re-upload before the next refresh
+	return nil
+}
+
+// Temperature returns the last value given to SetTemperature.
+func (e *Epd) Temperature() (celsius float64, ok bool) {
+	return e.temperature, e.hasTemperature
+}
+
+// UpdateTemperature reads TemperatureSensor and applies the reading; it does
+// nothing when no sensor is set. Display, DisplayPartial and Clear call it
+// before every refresh.
+func (e *Epd) UpdateTemperature() error {
+	if e.TemperatureSensor == nil {
+		return nil
+	}
+	celsius, err := e.TemperatureSensor()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrTemperatureSensor, err)
+	}
+	if e.hasTemperature && celsius == e.temperature {
+		return nil
+	}
+	return e.SetTemperature(celsius)
+}
+
+// il91874Temperature fixes the temperature the controller uses instead of
+// its internal sensor (TSFIX in 0xE0, value in 0xE5).
+func il91874Temperature(celsius float64) []Command {
+	t := math.Max(-128, math.Min(127, math.Round(celsius)))
+	return []Command{
+		{Cmd: 0xE0, Data: []byte{0x02}},
+		{Cmd: 0xE5, Data: []byte{byte(int8(t))}},
+	}
+}
+
+// il3820Temperature writes the temperature register (0x1A), 12 bits in
+// 1/16 degree steps.
+func il3820Temperature(celsius float64) []Command {
+	v := int(math.Max(-2048, math.Min(2047, math.Round(celsius*16)))) & 0xFFF
+	return []Command{
+		{Cmd: 0x1A, Data: []byte{byte(v >> 4), byte(v << 4)}},
+	}
+}
@@ -0,0 +1,111 @@
+package epd
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestSetTemperaturePicksBand(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+
+	if err := e.SetTemperature(2); err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := em.Temperature(); !ok || c != 2 {
+		t.Fatalf("controller temperature %d (%v), want 2", c, ok)
+	}
+	black := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black)
+	e.Display(&black, MODE_MONO_DITHER_OFF)
+	cold := (&Waveform{Luts: Panel_2in7.MonoLut}).Scaled(2)
+	if !bytes.Equal(em.Lut(0x21), cold.Luts[1].Data) {
+		t.Fatalf("cold band waveform not uploaded: % x", em.Lut(0x21)[:6])
+	}
+
+	e.SetTemperature(20)
+	img := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.White)
+	e.Display(&img, MODE_MONO_DITHER_OFF)
+	if !bytes.Equal(em.Lut(0x21), lut_ww) {
+		t.Fatal("room temperature should use the built-in waveform")
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
+
+func TestTemperatureSensorDrivesDisplay(t *testing.T) {
+	e, em := newEmulatedEpd()
+	reading := 25.0
+	e.TemperatureSensor = func() (float64, error) { return reading, nil }
+	e.Setup()
+	if c, _ := em.Temperature(); c != 25 {
+		t.Fatalf("Setup sent %d, want 25", c)
+	}
+
+	reading = -3
+	img := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.White)
+	e.Display(&img, MODE_MONO_DITHER_OFF)
+	if c, _ := em.Temperature(); c != -3 {
+		t.Fatalf("Display sent %d, want -3", c)
+	}
+
+	e.TemperatureSensor = func() (float64, error) { return 0, errors.New("i2c timeout") }
+	if err := e.Display(&img, MODE_MONO_DITHER_OFF, WithForce()); !errors.Is(err, ErrTemperatureSensor) {
+		t.Fatalf("got %v, want ErrTemperatureSensor", err)
+	}
+}
+
+func TestTemperatureSensorDrivesPartialAndClear(t *testing.T) {
+	e, em := newEmulatedEpd()
+	reading := 25.0
+	e.TemperatureSensor = func() (float64, error) { return reading, nil }
+	e.Setup()
+
+	reading = 4
+	if err := e.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := em.Temperature(); c != 4 {
+		t.Fatalf("Clear sent %d, want 4", c)
+	}
+
+	reading = -7
+	black := newUniformImage(EPD_WIDTH, EPD_HEIGHT, color.Black)
+	if err := e.DisplayPartial(black, image.Rect(0, 0, 32, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if em.PartialRefreshes() != 1 {
+		t.Fatalf("%d partial refreshes, want 1", em.PartialRefreshes())
+	}
+	if c, _ := em.Temperature(); c != -7 {
+		t.Fatalf("DisplayPartial sent %d, want -7", c)
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
+
+func TestScaledWaveformKeepsPhases(t *testing.T) {
+	w := (&Waveform{Luts: Panel_2in7.MonoLut}).Scaled(0.01)
+	if err := w.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Luts[0].Data[3] != 1 {
+		t.Fatalf("frame count %d, want clamped to 1", w.Luts[0].Data[3])
+	}
+	if got := il3820Temperature(-1)[0].Data; got[0] != 0xFF || got[1] != 0x00 {
+		t.Fatalf("il3820 -1°C encoded % x, want ff 00", got)
+	}
+}
+
+func TestTemperatureBandsMustIncrease(t *testing.T) {
+	e, _ := newFakeEpd()
+	e.TemperatureBands = []TemperatureBand{{Below: 10, Scale: 1}, {Below: math.Inf(1), Scale: 1}, {Below: 0}}
+	if err := e.SetTemperature(5); !errors.Is(err, ErrTemperatureBands) {
+		t.Fatalf("got %v, want ErrTemperatureBands", err)
+	}
+}
@@ -0,0 +1,289 @@
+package epd
+
+import (
+	"image"
+	"math"
+
+	"github.com/mipsmonsta/epd/imageutil"
+)
+
+// Thresholder decides which pixels of a grayscale image are black and which
+// are white. The Quantizer it returns is used as is for plain thresholding
+// and handed to a Ditherer otherwise. Global methods give a single cut,
+// local ones a cut per pixel in Quantizer.Local.
+type Thresholder interface {
+	Quantizer(gray *image.Gray) Quantizer
+}
+
+// FixedThreshold makes levels up to its value black.
+type FixedThreshold int
+
+func (t FixedThreshold) Quantizer(gray *image.Gray) Quantizer {
+	return MonoQuantizer(int(t))
+}
+
+// HistogramThreshold picks one cut for the whole image from its histogram.
+type HistogramThreshold struct {
+	Name string
+	pick func(hist []int, numPixels int) int
+}
+
+var (
+	Otsu          = &HistogramThreshold{Name: "Otsu", pick: otsuThreshold}
+	Triangle      = &HistogramThreshold{Name: "triangle", pick: triangleThreshold}
+	MeanThreshold = &HistogramThreshold{Name: "mean", pick: meanThreshold}
+	ISODATA       = &HistogramThreshold{Name: "ISODATA", pick: isodataThreshold}
+)
+
+func (t *HistogramThreshold) String() string { return t.Name }
+
+func (t *HistogramThreshold) Quantizer(gray *image.Gray) Quantizer {
+	return MonoQuantizer(t.pick(grayHistogram(gray), gray.Rect.Dx()*gray.Rect.Dy()))
+}
+
+// meanThreshold cuts at the average level.
+func meanThreshold(hist []int, numPixels int) int {
+	if numPixels == 0 {
+		return 127
+	}
+	sum := 0
+	for v, n := range hist {
+		sum += v * n
+	}
+	return sum / numPixels
+}
+
+// isodataThreshold starts at the mean and moves the cut to halfway between
+// the means of the two classes until it settles.
+func isodataThreshold(hist []int, numPixels int) int {
+	t := meanThreshold(hist, numPixels)
+	for i := 0; i < 256; i++ {
+		var n0, s0, n1, s1 int
+		for v, n := range hist {
+			if v <= t {
+				n0, s0 = n0+n, s0+v*n
+			} else {
+				n1, s1 = n1+n, s1+v*n
+			}
+		}
+		if n0 == 0 || n1 == 0 {
+			return t
+		}
+		next := (s0/n0 + s1/n1) / 2
+		if next == t {
+			break
+		}
+		t = next
+	}
+	return t
+}
+
+// triangleThreshold draws a line from the histogram peak to the end of its
+// longer tail and cuts at the level furthest below that line. It suits
+// images that are mostly background with a little ink.
+func triangleThreshold(hist []int, _ int) int {
+	lo, hi, peak := -1, 0, 0
+	for v, n := range hist {
+		if n == 0 {
+			continue
+		}
+		if lo < 0 {
+			lo = v
+		}
+		hi = v
+		if n > hist[peak] {
+			peak = v
+		}
+	}
+	if lo < 0 || lo == hi {
+		return 127
+	}
+
+	end := hi
+	if peak-lo > hi-peak {
+		end = lo
+	}
+	dx, dy := float64(peak-end), float64(hist[peak]-hist[end])
+	best, threshold := -1.0, peak
+	from, to := end, peak
+	if from > to {
+		from, to = to, from
+	}
+	for v := from; v <= to; v++ {
+		d := math.Abs(dy*float64(v-end) - dx*float64(hist[v]-hist[end]))
+		if d > best {
+			best, threshold = d, v
+		}
+	}
+	return threshold
+}
+
+// Bradley compares each pixel with the mean of the Window x Window square
+// around it and makes it black when it is more than T below it. Fields are
+// used as given, so T 0 cuts at the mean; NewBradley sets the usual T.
+type Bradley struct {
+	Window int //0 means an eighth of the shorter side
+	T      float64
+}
+
+// NewBradley is Bradley with T 0.15.
+func NewBradley(window int) Bradley {
+	return Bradley{Window: window, T: 0.15}
+}
+
+func (t Bradley) Quantizer(gray *image.Gray) Quantizer {
+	return localQuantizer(gray, t.Window, func(mean, std float64) float64 {
+		return mean * (1 - t.T)
+	})
+}
+
+// Sauvola cuts at mean * (1 + K * (std / R - 1)) over the window, which
+// keeps flat dark areas from turning into noise. K is used as given; R,
+// the dynamic range of std, must be positive and 0 means 128. NewSauvola
+// sets the usual K and R.
+type Sauvola struct {
+	Window int //0 means an eighth of the shorter side
+	K      float64
+	R      float64
+}
+
+// NewSauvola is Sauvola with K 0.34 and R 128.
+func NewSauvola(window int) Sauvola {
+	return Sauvola{Window: window, K: 0.34, R: 128}
+}
+
+func (t Sauvola) Quantizer(gray *image.Gray) Quantizer {
+	r := t.R
+	if r <= 0 {
+		r = 128 //std / 0 is not a cut
+	}
+	return localQuantizer(gray, t.Window, func(mean, std float64) float64 {
+		return mean * (1 + t.K*(std/r-1))
+	})
+}
+
+// Niblack cuts at mean + K * std over the window. K is used as given, so
+// K 0 is the plain local mean; NewNiblack sets the usual K.
+type Niblack struct {
+	Window int //0 means an eighth of the shorter side
+	K      float64
+}
+
+// NewNiblack is Niblack with K -0.2.
+func NewNiblack(window int) Niblack {
+	return Niblack{Window: window, K: -0.2}
+}
+
+func (t Niblack) Quantizer(gray *image.Gray) Quantizer {
+	return localQuantizer(gray, t.Window, func(mean, std float64) float64 {
+		return mean + t.K*std
+	})
+}
+
+// localQuantizer computes a cut per pixel from the mean and standard
+// deviation of the window around it, using integral images so the cost
+// does not grow with the window.
+func localQuantizer(gray *image.Gray, window int, cut func(mean, std float64) float64) Quantizer {
+	w, h := gray.Rect.Dx(), gray.Rect.Dy()
+	if window <= 0 {
+		window = w / 8
+		if h < w {
+			window = h / 8
+		}
+	}
+	if window < 3 {
+		window = 3
+	}
+	half := window / 2
+
+	sum := make([]float64, (w+1)*(h+1))
+	sq := make([]float64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		var rowSum, rowSq float64
+		for x := 0; x < w; x++ {
+			v := float64(gray.Pix[y*gray.Stride+x])
+			rowSum += v
+			rowSq += v * v
+			i := (x + 1) + (y+1)*(w+1)
+			sum[i] = sum[i-(w+1)] + rowSum
+			sq[i] = sq[i-(w+1)] + rowSq
+		}
+	}
+	area := func(t []float64, x0, y0, x1, y1 int) float64 {
+		return t[x1+y1*(w+1)] - t[x0+y1*(w+1)] - t[x1+y0*(w+1)] + t[x0+y0*(w+1)]
+	}
+
+	local := image.NewGray(image.Rect(0, 0, w, h))
+	imageutil.ParallelRows(h, func(from, to int) {
+		for y := from; y < to; y++ {
+			y0, y1 := maxInt(0, y-half), minInt(h, y+half+1)
+			for x := 0; x < w; x++ {
+				x0, x1 := maxInt(0, x-half), minInt(w, x+half+1)
+				n := float64((x1 - x0) * (y1 - y0))
+				mean := area(sum, x0, y0, x1, y1) / n
+				std := math.Sqrt(math.Max(0, area(sq, x0, y0, x1, y1)/n-mean*mean))
+				local.Pix[x+y*local.Stride] = uint8(clampGray(int(math.Floor(cut(mean, std)))))
+			}
+		}
+	})
+	q := MonoQuantizer(127)
+	q.Local = local
+	return q
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// thresholdImage quantizes every pixel on its own, without dithering.
+func thresholdImage(gray *image.Gray, q Quantizer) *image.Paletted {
+	out := image.NewPaletted(gray.Rect, q.palette())
+	imageutil.ParallelRows(gray.Rect.Dy(), func(y0, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := 0; x < gray.Rect.Dx(); x++ {
+				out.Pix[x+y*out.Stride] = uint8(q.LevelAt(int(gray.Pix[x+y*gray.Stride]), x, y))
+			}
+		}
+	})
+	return out
+}
+
+// ConvertImagetoMonochromeEPDTensorWith converts img with the thresholder
+// and ditherer of choice; nil t means Otsu and nil d no dithering.
+func ConvertImagetoMonochromeEPDTensorWith(img *image.Image, t Thresholder, d Ditherer) (monochrome [][]uint8, err error) {
+	gray := imageutil.ToGray(*img)
+	if t == nil {
+		t = Otsu
+	}
+	q := t.Quantizer(gray)
+	if d == nil {
+		return levelTensor(thresholdImage(gray, q), monoTensorLevels), nil
+	}
+	return levelTensor(d.Dither(gray, q), monoTensorLevels), nil
+}
+
+// monochrome is the mono conversion of Display and DisplayPartial: the
+// built-in converters unless a Thresholder or Ditherer is given.
+func (o displayOptions) monochrome(img *image.Image, dither bool) ([][]uint8, error) {
+	switch {
+	case !dither && o.thresholder == nil:
+		return ConvertImagetoMonochromeEPDTensor(img)
+	case !dither:
+		return ConvertImagetoMonochromeEPDTensorWith(img, o.thresholder, nil)
+	case o.ditherer == nil && o.thresholder == nil:
+		return ConvertImagetoMonochromeEPDTensorWithDither(img)
+	case o.ditherer == nil:
+		return ConvertImagetoMonochromeEPDTensorWith(img, o.thresholder, FloydSteinberg)
+	}
+	return ConvertImagetoMonochromeEPDTensorWith(img, o.thresholder, o.ditherer)
+}
@@ -0,0 +1,133 @@
+package epd
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/mipsmonsta/epd/emulator"
+)
+
+// bimodal: a quarter of the pixels at 40, the rest at 200
+func bimodalGray() *image.Gray {
+	g := flatGray(16, 16, 200)
+	for i := 0; i < len(g.Pix)/4; i++ {
+		g.Pix[i] = 40
+	}
+	return g
+}
+
+func TestGlobalThresholders(t *testing.T) {
+	for _, th := range []Thresholder{Otsu, Triangle, MeanThreshold, ISODATA} {
+		q := th.Quantizer(bimodalGray())
+		if cut := q.Cuts[0]; cut < 40 || cut >= 200 {
+			t.Errorf("%v cut %d, want between the modes", th, cut)
+		}
+		if q.Local != nil {
+			t.Errorf("%v should be global", th)
+		}
+	}
+	if q := FixedThreshold(90).Quantizer(bimodalGray()); q.Level(90) != 0 || q.Level(91) != 1 {
+		t.Fatalf("fixed 90 cuts at %v", q.Cuts)
+	}
+}
+
+// unevenlyLit is a page whose background brightens from 60 on the left to
+// 250 on the right, with a vertical stroke at half the background every 8
+// pixels.
+func unevenlyLit() (g *image.Gray, stroke func(x int) bool) {
+	stroke = func(x int) bool { return x%8 == 3 }
+	g = image.NewGray(image.Rect(0, 0, 96, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 96; x++ {
+			v := 60 + x*190/95
+			if stroke(x) {
+				v /= 2
+			}
+			g.Pix[x+y*g.Stride] = uint8(v)
+		}
+	}
+	return
+}
+
+func TestLocalThresholdersFollowUnevenLight(t *testing.T) {
+	g, stroke := unevenlyLit()
+	for _, th := range []Thresholder{NewBradley(15), NewSauvola(15), NewNiblack(15)} {
+		out := thresholdImage(g, th.Quantizer(g))
+		for x := 8; x < 88; x++ { //away from the clipped windows at the borders
+			want := uint8(1)
+			if stroke(x) {
+				want = 0
+			}
+			if got := out.Pix[x+16*out.Stride]; got != want {
+				t.Errorf("%T at x %d: level %d, want %d", th, x, got, want)
+				break
+			}
+		}
+	}
+
+	//a global cut loses the strokes on one side or the background on the other
+	out := thresholdImage(g, Otsu.Quantizer(g))
+	if out.Pix[0+16*out.Stride] == 1 && out.Pix[91+16*out.Stride] == 0 {
+		t.Fatalf("uneven page is too easy for a global threshold")
+	}
+}
+
+func TestLocalThresholderWithDitherer(t *testing.T) {
+	g, _ := unevenlyLit()
+	q := NewSauvola(15).Quantizer(g)
+	for _, d := range []Ditherer{FloydSteinberg, Bayer4} {
+		out := d.Dither(g, q)
+		if out.Pix[3+16*out.Stride] != 0 || out.Pix[95+16*out.Stride] != 1 {
+			t.Errorf("%v lost the stroke or the background", d)
+		}
+	}
+}
+
+func TestEmulatedDisplayWithThresholder(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+
+	//dark sidebar with a light label, white page with dark text
+	src := image.NewRGBA(image.Rect(0, 0, EPD_WIDTH, EPD_HEIGHT))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, 40, EPD_HEIGHT), image.NewUniform(color.Gray{Y: 30}), image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(10, 100, 30, 110), image.NewUniform(color.Gray{Y: 110}), image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(80, 100, 120, 110), image.Black, image.Point{}, draw.Src)
+	img := image.Image(src)
+
+	for _, mode := range []Mode{MODE_MONO_DITHER_OFF, MODE_MONO_DITHER_ON} {
+		if err := e.Display(&img, mode, WithThresholder(NewSauvola(31)), WithForce()); err != nil {
+			t.Fatal(err)
+		}
+		out := em.Image(emulator.RENDER_MONO)
+		if got := out.At(20, 105); got != color.White {
+			t.Errorf("mode %d: label in the sidebar %v, want white", mode, got)
+		}
+		if got := out.At(100, 105); got != color.Black {
+			t.Errorf("mode %d: text %v, want black", mode, got)
+		}
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}
+
+func TestLocalThresholdFieldsAreLiteral(t *testing.T) {
+	g := image.NewGray(image.Rect(0, 0, 32, 32))
+	for i := range g.Pix {
+		g.Pix[i] = uint8(i * 37 % 251)
+	}
+	mean := Bradley{Window: 5}.Quantizer(g).Local
+	if niblack := (Niblack{Window: 5}).Quantizer(g).Local; !bytes.Equal(niblack.Pix, mean.Pix) {
+		t.Fatal("Niblack with K 0 is not the local mean")
+	}
+	if usual := NewNiblack(5).Quantizer(g).Local; bytes.Equal(usual.Pix, mean.Pix) {
+		t.Fatal("NewNiblack did not set K")
+	}
+	if usual := NewBradley(5).Quantizer(g).Local; bytes.Equal(usual.Pix, mean.Pix) {
+		t.Fatal("NewBradley did not set T")
+	}
+}
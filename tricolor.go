@@ -0,0 +1,54 @@
+package epd
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/mipsmonsta/epd/imageutil"
+)
+
+// RedDetector decides whether a pixel goes to the red plane of a tri-color
+// panel.
+type RedDetector func(c color.RGBA) bool
+
+// RedByThreshold treats a pixel as red when its red channel is at least
+// minRed and both green and blue are at most maxGreenBlue.
+func RedByThreshold(minRed, maxGreenBlue uint8) RedDetector {
+	return func(c color.RGBA) bool {
+		return c.R >= minRed && c.G <= maxGreenBlue && c.B <= maxGreenBlue
+	}
+}
+
+var DefaultRedDetector = RedByThreshold(128, 96)
+
+// ConvertImagetoTriColorEPDTensors splits img into a black and a red tensor,
+// both 0 for ink and 255 for white. Pixels isRed accepts go to red; the
+// rest are thresholded to black or white as in the mono mode.
+func ConvertImagetoTriColorEPDTensors(img *image.Image, isRed RedDetector) (black, red [][]uint8, err error) {
+	if isRed == nil {
+		isRed = DefaultRedDetector
+	}
+	rgba, ok := (*img).(*image.RGBA)
+	if !ok || imageutil.HasAlpha(rgba) {
+		rgba = imageutil.Flatten(*img, nil) //transparent pixels are white, not black or red
+	}
+	g := imageutil.ToGray(rgba)
+	threshold := otsuThreshold(grayHistogram(g), len(g.Pix))
+
+	size := g.Rect.Size()
+	black, red = make([][]uint8, size.X), make([][]uint8, size.X)
+	for x := 0; x < size.X; x++ {
+		blackCol := make([]uint8, size.Y)
+		redCol := make([]uint8, size.Y)
+		for y := 0; y < size.Y; y++ {
+			blackCol[y], redCol[y] = 255, 255
+			if isRed(rgba.RGBAAt(rgba.Rect.Min.X+x, rgba.Rect.Min.Y+y)) {
+				redCol[y] = 0
+			} else if int(g.Pix[x+y*g.Stride]) <= threshold {
+				blackCol[y] = 0
+			}
+		}
+		black[x], red[x] = blackCol, redCol
+	}
+	return
+}
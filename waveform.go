@@ -0,0 +1,384 @@
+package epd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// WaveformSlot names the LUT set a waveform replaces on a running Epd.
+type WaveformSlot int
+
+const (
+	lutUnknown     WaveformSlot = 0
+	WAVEFORM_FULL  WaveformSlot = 1 //Panel.MonoLut, REFRESH_FULL
+	WAVEFORM_FAST  WaveformSlot = 2 //Panel.FastLut, REFRESH_FAST
+	WAVEFORM_GRAY4 WaveformSlot = 3 //Panel.Gray4Lut, Display_4Gray
+)
+
+var (
+	ErrWaveform     = errors.New("invalid waveform")
+	ErrWaveformSlot = errors.New("unknown waveform slot")
+)
+
+// IL91874 LUT registers: 0x20 VCOM is 2 bytes followed by 7 groups, 0x21 -
+// 0x25 are 7 groups. A group is a level select byte, 4 frame counts and a
+// repeat count.
+const (
+	lutGroups      = 7
+	lutGroupSize   = 6
+	lutVcomPrefix  = 2
+	lutFirstReg    = 0x20
+	lutLastReg     = 0x25
+	waveformMagic  = "EPDW"
+	waveformFormat = 1
+)
+
+// Waveform is a named set of LUT tables for registers 0x20 - 0x25. It can
+// be read from JSON or from the binary format written by MarshalBinary and
+// applied to a running Epd with SetWaveform.
+type Waveform struct {
+	Name string
+	Luts LutSet
+}
+
+func lutLength(reg byte) int {
+	if reg == lutFirstReg {
+		return lutVcomPrefix + lutGroups*lutGroupSize
+	}
+	return lutGroups * lutGroupSize
+}
+
+// Validate checks that 0x20 - 0x24 are present once each, 0x25 at most once, that every table
+// has the length of its register and that the phases are well formed: an
+// unused group (repeat 0) is all zero and no group follows an unused one.
+func (w *Waveform) Validate() error {
+	seen := map[byte]bool{}
+	for _, l := range w.Luts {
+		if l.Register < lutFirstReg || l.Register > lutLastReg {
+			return fmt.Errorf("%w: register %#02x is not a LUT register", ErrWaveform, l.Register)
+		}
+		if seen[l.Register] {
+			return fmt.Errorf("%w: register %#02x appears twice", ErrWaveform, l.Register)
+		}
+		seen[l.Register] = true
+		if len(l.Data) != lutLength(l.Register) {
+			return fmt.Errorf("%w: register %#02x has %d bytes, want %d", ErrWaveform, l.Register, len(l.Data), lutLength(l.Register))
+		}
+
+		groups := l.Data
+		if l.Register == lutFirstReg {
+			groups = groups[lutVcomPrefix:]
+		}
+		active := 0
+		for g := 0; g < lutGroups; g++ {
+			group := groups[g*lutGroupSize : (g+1)*lutGroupSize]
+			if group[lutGroupSize-1] == 0 {
+				if !bytes.Equal(group, make([]byte, lutGroupSize)) {
+					return fmt.Errorf("%w: register %#02x group %d has repeat 0 but is not empty", ErrWaveform, l.Register, g)
+				}
+				continue
+			}
+			if active != g {
+				return fmt.Errorf("%w: register %#02x group %d follows an unused group", ErrWaveform, l.Register, g)
+			}
+			active++
+		}
+		if active == 0 {
+			return fmt.Errorf("%w: register %#02x has no phases", ErrWaveform, l.Register)
+		}
+	}
+	return w.require(lutLastReg - 1)
+}
+
+// ValidateFor is Validate for the waveform of slot: WAVEFORM_GRAY4 tables
+// also need 0x25, which Display_4Gray uploads.
+func (w *Waveform) ValidateFor(slot WaveformSlot) error {
+	if slot < WAVEFORM_FULL || slot > WAVEFORM_GRAY4 {
+		return fmt.Errorf("%w: %d", ErrWaveformSlot, slot)
+	}
+	if err := w.Validate(); err != nil {
+		return err
+	}
+	if slot == WAVEFORM_GRAY4 {
+		return w.require(lutLastReg)
+	}
+	return nil
+}
+
+// require checks that every register from 0x20 to last has a table.
+func (w *Waveform) require(last byte) error {
+	seen := map[byte]bool{}
+	for _, l := range w.Luts {
+		seen[l.Register] = true
+	}
+	for reg := byte(lutFirstReg); reg <= last; reg++ {
+		if !seen[reg] {
+			return fmt.Errorf("%w: register %#02x is missing", ErrWaveform, reg)
+		}
+	}
+	return nil
+}
+
+type waveformJSON struct {
+	Name string        `json:"name"`
+	Luts []lutJSONData `json:"luts"`
+}
+
+type lutJSONData struct {
+	Register string `json:"register"` //e.g. "0x20"
+	Data     []int  `json:"data"`
+}
+
+func (w *Waveform) MarshalJSON() ([]byte, error) {
+	out := waveformJSON{Name: w.Name}
+	for _, l := range w.Luts {
+		data := make([]int, len(l.Data))
+		for i, b := range l.Data {
+			data[i] = int(b)
+		}
+		out.Luts = append(out.Luts, lutJSONData{Register: fmt.Sprintf("%#02x", l.Register), Data: data})
+	}
+	return json.Marshal(out)
+}
+
+func (w *Waveform) UnmarshalJSON(b []byte) error {
+	var in waveformJSON
+	if err := json.Unmarshal(b, &in); err != nil {
+		return err
+	}
+	luts := LutSet{}
+	for _, l := range in.Luts {
+		reg, err := strconv.ParseUint(l.Register, 0, 8)
+		if err != nil {
+			return fmt.Errorf("%w: register %q", ErrWaveform, l.Register)
+		}
+		data := make([]byte, len(l.Data))
+		for i, v := range l.Data {
+			if v < 0 || v > 0xff {
+				return fmt.Errorf("%w: register %s byte %d is %d", ErrWaveform, l.Register, i, v)
+			}
+			data[i] = byte(v)
+		}
+		luts = append(luts, LutRegister{Register: byte(reg), Data: data})
+	}
+	w.Name, w.Luts = in.Name, luts
+	return nil
+}
+
+// MarshalBinary encodes w as "EPDW", a format byte, the name length and
+// name, the table count and then register, length and data per table.
+func (w *Waveform) MarshalBinary() ([]byte, error) {
+	if len(w.Name) > 0xff || len(w.Luts) > 0xff {
+		return nil, fmt.Errorf("%w: name or table count too long", ErrWaveform)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(waveformMagic)
+	buf.WriteByte(waveformFormat)
+	buf.WriteByte(byte(len(w.Name)))
+	buf.WriteString(w.Name)
+	buf.WriteByte(byte(len(w.Luts)))
+	for _, l := range w.Luts {
+		if len(l.Data) > 0xff {
+			return nil, fmt.Errorf("%w: register %#02x has %d bytes", ErrWaveform, l.Register, len(l.Data))
+		}
+		buf.WriteByte(l.Register)
+		buf.WriteByte(byte(len(l.Data)))
+		buf.Write(l.Data)
+	}
+	return buf.Bytes(), nil
+}
+
+func (w *Waveform) UnmarshalBinary(b []byte) error {
+	r := bytes.NewReader(b)
+	next := func(n int) ([]byte, error) {
+		out := make([]byte, n)
+		if _, err := io.ReadFull(r, out); err != nil {
+			return nil, fmt.Errorf("%w: truncated", ErrWaveform)
+		}
+		return out, nil
+	}
+
+	header, err := next(len(waveformMagic) + 2)
+	if err != nil {
+		return err
+	}
+	if string(header[:len(waveformMagic)]) != waveformMagic {
+		return fmt.Errorf("%w: bad magic", ErrWaveform)
+	}
+	if header[len(waveformMagic)] != waveformFormat {
+		return fmt.Errorf("%w: format %d", ErrWaveform, header[len(waveformMagic)])
+	}
+	name, err := next(int(header[len(waveformMagic)+1]))
+	if err != nil {
+		return err
+	}
+	count, err := next(1)
+	if err != nil {
+		return err
+	}
+	luts := LutSet{}
+	for i := 0; i < int(count[0]); i++ {
+		head, err := next(2)
+		if err != nil {
+			return err
+		}
+		data, err := next(int(head[1]))
+		if err != nil {
+			return err
+		}
+		luts = append(luts, LutRegister{Register: head[0], Data: data})
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrWaveform, r.Len())
+	}
+	w.Name, w.Luts = string(name), luts
+	return nil
+}
+
+// ReadWaveform decodes a JSON or binary waveform from r and validates it.
+func ReadWaveform(r io.Reader) (*Waveform, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	w := &Waveform{}
+	if bytes.HasPrefix(b, []byte(waveformMagic)) {
+		err = w.UnmarshalBinary(b)
+	} else {
+		err = json.Unmarshal(b, w)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// LoadWaveform reads a waveform file; the name defaults to the file name.
+func LoadWaveform(path string) (*Waveform, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	w, err := ReadWaveform(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if w.Name == "" {
+		w.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return w, nil
+}
+
+// SaveWaveform writes w as JSON when path ends in .json and in the binary
+// format otherwise.
+func SaveWaveform(w *Waveform, path string) error {
+	var b []byte
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		b, err = json.MarshalIndent(w, "", "  ")
+	} else {
+		b, err = w.MarshalBinary()
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// panelLut is the panel's built-in table set for slot.
+func (p *Panel) panelLut(slot WaveformSlot) LutSet {
+	switch slot {
+	case WAVEFORM_FULL:
+		return p.MonoLut
+	case WAVEFORM_FAST:
+		return p.FastLut
+	case WAVEFORM_GRAY4:
+		return p.Gray4Lut
+	}
+	return nil
+}
+
+// programmableLut reports whether the panel takes its waveform in the
+// 0x20 - 0x25 registers rather than from OTP or another LUT format.
+func (p *Panel) programmableLut() bool {
+	if len(p.MonoLut) == 0 {
+		return false
+	}
+	for _, l := range p.MonoLut {
+		if l.Register < lutFirstReg || l.Register > lutLastReg {
+			return false
+		}
+	}
+	return true
+}
+
+// lut is the table set in use for slot: a custom waveform if one is set,
+// then the current temperature band's, then the panel's.
+func (e *Epd) lut(slot WaveformSlot) LutSet {
+	if w, ok := e.waveforms[slot]; ok {
+		return w.Luts
+	}
+	if luts, ok := e.bandLuts[slot]; ok {
+		return luts
+	}
+	return e.panel().panelLut(slot)
+}
+
+// Waveform returns the waveform in use for slot, the panel's built-in one
+// (or its temperature band's) unless SetWaveform replaced it; nil when the
+// panel has none.
+func (e *Epd) Waveform(slot WaveformSlot) *Waveform {
+	if w, ok := e.waveforms[slot]; ok {
+		return w
+	}
+	luts := e.lut(slot)
+	if len(luts) == 0 {
+		return nil
+	}
+	return &Waveform{Name: e.panel().Name, Luts: luts}
+}
+
+// SetWaveform replaces the tables for slot after validating them; nil goes
+// back to the panel's built-in ones. If slot is the waveform loaded in the
+// controller right now it is uploaded straight away, so no Setup is needed.
+func (e *Epd) SetWaveform(slot WaveformSlot, w *Waveform) error {
+	p := e.panel()
+	if slot < WAVEFORM_FULL || slot > WAVEFORM_GRAY4 {
+		return fmt.Errorf("%w: %d", ErrWaveformSlot, slot)
+	}
+	if !p.programmableLut() {
+		return fmt.Errorf("waveforms on %s: %w", p.Name, ErrUnsupported)
+	}
+
+	if w == nil {
+		delete(e.waveforms, slot)
+	} else {
+		if err := w.ValidateFor(slot); err != nil {
+			return err
+		}
+		if e.waveforms == nil {
+			e.waveforms = map[WaveformSlot]*Waveform{}
+		}
+		e.waveforms[slot] = w
+	}
+
+	if e.loadedLut == slot {
+		if err := e.uploadLut(e.lut(slot)); err != nil {
+			e.loadedLut = lutUnknown
+			return err
+		}
+	}
+	return nil
+}
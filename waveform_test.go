@@ -0,0 +1,125 @@
+package epd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinWaveformsAreValid(t *testing.T) {
+	e, _ := newFakeEpd()
+	for _, slot := range []WaveformSlot{WAVEFORM_FULL, WAVEFORM_FAST, WAVEFORM_GRAY4} {
+		if err := e.Waveform(slot).ValidateFor(slot); err != nil {
+			t.Errorf("slot %d: %v", slot, err)
+		}
+	}
+}
+
+func TestWaveformRoundTrip(t *testing.T) {
+	e, _ := newFakeEpd()
+	w := e.Waveform(WAVEFORM_GRAY4)
+	dir := t.TempDir()
+	for _, name := range []string{"gray.json", "gray.lut"} {
+		path := filepath.Join(dir, name)
+		if err := SaveWaveform(w, path); err != nil {
+			t.Fatal(err)
+		}
+		got, err := LoadWaveform(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != w.Name || !reflect.DeepEqual(got.Luts, w.Luts) {
+			t.Fatalf("%s: waveform changed on the round trip", name)
+		}
+	}
+}
+
+func TestWaveformValidate(t *testing.T) {
+	e, _ := newFakeEpd()
+	clone := func() *Waveform {
+		w := &Waveform{}
+		b, _ := json.Marshal(e.Waveform(WAVEFORM_FULL))
+		json.Unmarshal(b, w)
+		return w
+	}
+
+	short := clone()
+	short.Luts[1].Data = short.Luts[1].Data[:40]
+	missing := clone()
+	missing.Luts = missing.Luts[:4]
+	gap := clone()
+	gap.Luts[2].Data[5] = 0 //first group unused, later ones active
+	for name, w := range map[string]*Waveform{"short": short, "missing": missing, "gap": gap} {
+		if err := w.Validate(); !errors.Is(err, ErrWaveform) {
+			t.Errorf("%s: got %v, want ErrWaveform", name, err)
+		}
+	}
+
+	if _, err := ReadWaveform(strings.NewReader(`{"luts": [{"register": "0x20", "data": [256]}]}`)); !errors.Is(err, ErrWaveform) {
+		t.Errorf("byte out of range: got %v", err)
+	}
+	if _, err := ReadWaveform(bytes.NewReader([]byte("EPDW\x01\x03ab"))); !errors.Is(err, ErrWaveform) {
+		t.Errorf("truncated binary: got %v", err)
+	}
+}
+
+func TestGray4WaveformNeeds0x25(t *testing.T) {
+	e, _ := newFakeEpd()
+	w := &Waveform{Name: "no 0x25"}
+	for _, l := range e.Waveform(WAVEFORM_GRAY4).Luts {
+		if l.Register != 0x25 {
+			w.Luts = append(w.Luts, l)
+		}
+	}
+	if err := w.ValidateFor(WAVEFORM_FULL); err != nil {
+		t.Fatalf("mono slot: %v", err)
+	}
+	if err := w.ValidateFor(WAVEFORM_GRAY4); !errors.Is(err, ErrWaveform) {
+		t.Fatalf("gray slot: got %v, want ErrWaveform", err)
+	}
+	if err := e.SetWaveform(WAVEFORM_GRAY4, w); !errors.Is(err, ErrWaveform) {
+		t.Fatalf("SetWaveform: got %v, want ErrWaveform", err)
+	}
+	if err := w.ValidateFor(7); !errors.Is(err, ErrWaveformSlot) {
+		t.Fatalf("slot 7: got %v, want ErrWaveformSlot", err)
+	}
+}
+
+func TestSetWaveformHotSwap(t *testing.T) {
+	e, em := newEmulatedEpd()
+	e.Setup()
+
+	w := &Waveform{Name: "custom"}
+	for _, l := range e.Waveform(WAVEFORM_FULL).Luts {
+		data := append([]byte(nil), l.Data...)
+		if l.Register != 0x20 {
+			data[0] ^= 0x40
+		}
+		w.Luts = append(w.Luts, LutRegister{Register: l.Register, Data: data})
+	}
+	if err := e.SetWaveform(WAVEFORM_FULL, w); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(em.Lut(0x21), w.Luts[1].Data) {
+		t.Fatal("loaded waveform not uploaded straight away")
+	}
+
+	if err := e.SetWaveform(WAVEFORM_FULL, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(em.Lut(0x21), lut_ww) {
+		t.Fatal("built-in waveform not restored")
+	}
+
+	other, _ := NewEpd(em, MODEL_4IN2)
+	if err := other.SetWaveform(WAVEFORM_FULL, w); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("OTP panel: got %v, want ErrUnsupported", err)
+	}
+	if len(em.Errors()) != 0 {
+		t.Fatalf("protocol errors: %v", em.Errors())
+	}
+}